@@ -2,104 +2,176 @@ package converter
 
 import (
 	"fmt"
+	"md2html/lexer"
 	"md2html/parser"
 	"os"
 	"strings"
+	"unicode"
 )
 
+// HTMLRenderer renders an AST into HTML, it is the Renderer of ConvertDocument.
+// It holds the state of a single conversion, so every document should get its own HTMLRenderer.
+type HTMLRenderer struct {
+	options Options
+	// policy is the policy of safe mode, it is nil if safe mode is off.
+	policy *Policy
+	// toc is the table of contents, and tocDone is set once it is added.
+	toc     []*TOCEntry
+	tocDone bool
+	// headerRow is set while the cells of a header row are rendered.
+	headerRow bool
+}
+
+// NewHTMLRenderer returns an HTMLRenderer for the options.
+func NewHTMLRenderer(options Options) *HTMLRenderer {
+	r := &HTMLRenderer{options: options}
+	if options.Safe {
+		r.policy = options.Policy
+		if r.policy == nil {
+			r.policy = DefaultPolicy()
+		}
+	}
+	return r
+}
+
+// Document is the result of converting a markdown document.
+type Document struct {
+	// HTML is the full page, or only the article if FullPage is off.
+	HTML string
+	// TOC is the table of contents, it is only built if the TOC option is on.
+	TOC []*TOCEntry
+	// Metadata is the front matter of the document, nil if it has none.
+	Metadata map[string]interface{}
+}
+
+// Convert converts markdown with the default options, the AST is printed if the environment variable MODE is "debug".
 func Convert(markdown string, fullPage bool) (html string) {
-	ast := parser.Parse(markdown)
+	options := DefaultOptions()
+	options.FullPage = fullPage
 	if os.Getenv("MODE") == "debug" {
-		parser.PrintAST(ast)
-	}
-	html = processArticleNode(ast)
-	if fullPage {
-		html = fmt.Sprintf(HtmlTemplate, Style, html)
+		options.Debug = os.Stdout
 	}
-	return html
+	return ConvertWithOptions(markdown, options)
 }
 
-func processArticleNode(node *parser.Node) (html string) {
-	for _, child := range node.Children {
-		switch child.Type {
-		case parser.TitleNode:
-			html += processTitleNode(child)
-		case parser.DividingLineNode:
-			html += processDividingLineNode(child)
-		case parser.ContentNode:
-			content := processContentNode(child)
-			html += fmt.Sprintf("<div>%s</div>\n", content)
-		case parser.ListNode:
-			html += processListNode(child)
-		case parser.QuoteNode:
-			html += processQuoteNode(child)
-		case parser.CodeBlockNode:
-			html += processCodeBlockNode(child)
+func ConvertWithOptions(markdown string, options Options) (html string) {
+	return ConvertDocument(markdown, options).HTML
+}
+
+// ConvertDocument converts markdown like ConvertWithOptions, and returns the data collected from it as well.
+func ConvertDocument(markdown string, options Options) *Document {
+	p := parser.New(markdown)
+	p.Extensions = options.Extensions
+	return ConvertAST(p.Parse(), options)
+}
+
+// ConvertAST converts the AST of a document, which may be built or modified outside the parser,
+// such as an AST decoded from JSON. Options.Extensions has no effect since the AST is already parsed.
+func ConvertAST(ast *parser.Node, options Options) *Document {
+	parser.Transform(ast, options.Transformers...)
+	if options.Debug != nil {
+		parser.FprintAST(options.Debug, ast)
+	}
+	document := &Document{Metadata: ast.Metadata}
+	r := NewHTMLRenderer(options)
+	if options.HeadingIDs || options.HeadingAnchors || options.TOC {
+		assignHeadingIDs(ast)
+	}
+	if options.TOC {
+		minLevel, maxLevel := options.TOCMinLevel, options.TOCMaxLevel
+		if minLevel == 0 {
+			minLevel = 1
+		}
+		if maxLevel == 0 {
+			maxLevel = 6
 		}
+		document.TOC = BuildTOC(ast, minLevel, maxLevel)
+		r.toc = document.TOC
+	}
+	var renderer Renderer = r
+	if options.Renderer != nil {
+		renderer = options.Renderer(r)
+	}
+	document.HTML = Render(renderer, ast)
+	if options.FullPage {
+		document.HTML = fillTemplate(options, newTemplateData(options, document))
+	}
+	return document
+}
+
+func (r *HTMLRenderer) Article(node *parser.Node, render RenderFunc) (html string) {
+	html = r.blocks(node.Children, render)
+	if r.options.TOC && !r.tocDone {
+		html = processTOC(r.toc) + html
 	}
 	html = fmt.Sprintf("<div class='article'>\n%s\n</div>", html)
 	return
 }
 
-func processTitleNode(node *parser.Node) (html string) {
-	content := processContentNode(node.Children[0])
-	level := int(node.Value[0])
-	html = fmt.Sprintf("<h%d>%s</h%d>\n", level, content, level)
+// blocks renders the block nodes, a ContentNode among them is rendered as a <div>.
+func (r *HTMLRenderer) blocks(nodes []*parser.Node, render RenderFunc) (html string) {
+	for _, child := range nodes {
+		if child.Type == parser.ContentNode {
+			html += fmt.Sprintf("<div%s>%s</div>\n", r.sourcePos(child), render(child))
+		} else {
+			html += render(child)
+		}
+	}
 	return
 }
 
-func processDividingLineNode(node *parser.Node) (html string) {
-	if node.Type == parser.DividingLineNode {
-		html = "<hr>\n"
+func (r *HTMLRenderer) Title(node *parser.Node, render RenderFunc) (html string) {
+	content := render(node.Children[0])
+	level := int(node.Value[0])
+	attributes := ""
+	if id := node.Attributes["id"]; id != "" {
+		attributes += fmt.Sprintf(" id='%s'", escapeAttribute(id))
+		if r.options.HeadingAnchors {
+			content += fmt.Sprintf("<a class='anchor' href='#%s' aria-hidden='true'>¶</a>", escapeAttribute(id))
+		}
+	}
+	if class := node.Attributes["class"]; class != "" {
+		attributes += fmt.Sprintf(" class='%s'", escapeAttribute(class))
 	}
+	html = fmt.Sprintf("<h%d%s%s>%s</h%d>\n", level, attributes, r.sourcePos(node), content, level)
 	return
 }
 
-func processContentNode(node *parser.Node) (html string) {
+func (r *HTMLRenderer) DividingLine(node *parser.Node, render RenderFunc) (html string) {
+	return fmt.Sprintf("<hr%s>\n", r.sourcePos(node))
+}
+
+func (r *HTMLRenderer) Paragraph(node *parser.Node, render RenderFunc) (html string) {
+	if r.options.TOC && !r.tocDone && isTOCMarker(node) {
+		r.tocDone = true
+		return processTOC(r.toc)
+	}
+	return fmt.Sprintf("<p%s>%s</p>\n", r.sourcePos(node), render(node.Children[0]))
+}
+
+func (r *HTMLRenderer) Content(node *parser.Node, render RenderFunc) (html string) {
 	for _, child := range node.Children {
-		switch child.Type {
-		case parser.TextNode:
-			html += fmt.Sprintf("%s", string(child.Value))
-		case parser.ItalicNode:
-			html += processRichTextNode(child, "i")
-		case parser.BoldNode:
-			html += processRichTextNode(child, "b")
-		case parser.InlineCodeNode:
-			html += processRichTextNode(child, "code")
-		case parser.StrikethroughNode:
-			html += processRichTextNode(child, "del")
-		case parser.LinkNode:
-			html += processLinkNode(child)
-		case parser.ImageNode:
-			html += processImageNode(child)
-		case parser.ContentNode:
-			html += processContentNode(child)
-		}
+		html += render(child)
 	}
-	html = fmt.Sprintf("%s", html)
 	return
 }
 
-func processListNode(node *parser.Node) (html string) {
+func (r *HTMLRenderer) List(node *parser.Node, render RenderFunc) (html string) {
 	if len(node.Children) == 0 {
 		return
 	}
-	if int(node.Children[0].Value[0])%2 == 0 {
-		// unordered list
-		html = "<ul>%s</ul>"
-	} else {
-		html = "<ol>%s</ol>"
-	}
 	content := ""
 	for _, child := range node.Children {
-		content += processSubListNode(child)
+		content += render(child)
 	}
-	html = fmt.Sprintf(html, content)
-	return
+	if isOrderedList(node.Children[0]) {
+		return fmt.Sprintf("<ol%s>%s</ol>", r.sourcePos(node), content)
+	}
+	return fmt.Sprintf("<ul%s>%s</ul>", r.sourcePos(node), content)
 }
 
-func processSubListNode(node *parser.Node) (html string) {
-	content := processContentNode(node.Children[0])
+func (r *HTMLRenderer) ListItem(node *parser.Node, render RenderFunc) (html string) {
+	content := render(node.Children[0])
 	i := strings.Index(content, ". ")
 	if i >= 0 && i < 5 {
 		i += 2
@@ -112,52 +184,196 @@ func processSubListNode(node *parser.Node) (html string) {
 		inputTag = "<input checked disabled type='checkbox'>"
 	}
 	subListContent := ""
-	if len(node.Children) > 1 {
-		subList := ""
-		if int(node.Children[1].Value[0])%2 == 0 {
-			subList = "<ul>%s</ul>"
-		} else {
-			subList = "<ol>%s</ol>"
+	for _, child := range node.Children[1:] {
+		if child.Type != parser.ListNode {
+			// Blocks like code blocks can be nested in a list item too.
+			subListContent += r.blocks([]*parser.Node{child}, render)
+			continue
 		}
-		for _, child := range node.Children[1:] {
-			subListContent += fmt.Sprintf(subList, processSubListNode(child))
+		// A sub-list item is rendered as a list on its own, so that it goes through List as well.
+		list := &parser.Node{Type: parser.ListNode, Value: []rune{-1, 0}, Children: []*parser.Node{child},
+			Start: child.Start, End: child.End}
+		subListContent += render(list)
+	}
+	html += fmt.Sprintf("<li%s>%s%s%s</li>", r.sourcePos(node), inputTag, content, subListContent)
+	return
+}
+
+func (r *HTMLRenderer) Quote(node *parser.Node, render RenderFunc) (html string) {
+	content := r.blocks(node.Children, render)
+	html = fmt.Sprintf("<blockquote%s>\n%s</blockquote>\n", r.sourcePos(node), content)
+	return
+}
+
+func (r *HTMLRenderer) CodeBlock(node *parser.Node, render RenderFunc) (html string) {
+	content := escapeHTML(string(node.Value))
+	if r.options.Highlight {
+		if highlighted, ok := Highlight(string(node.Value), node.Language()); ok {
+			content = highlighted
 		}
 	}
-	html += fmt.Sprintf("<li>%s%s%s</li>", inputTag, content, subListContent)
+	var classes []string
+	if language := node.Language(); language != "" {
+		classes = append(classes, "language-"+language)
+	}
+	if class := node.Attributes["class"]; class != "" {
+		classes = append(classes, class)
+	}
+	class := ""
+	if len(classes) != 0 {
+		class = fmt.Sprintf(" class='%s'", escapeAttribute(strings.Join(classes, " ")))
+	}
+	html = fmt.Sprintf("<pre%s><code%s>%s</code></pre>", r.sourcePos(node), class, content)
 	return
 }
 
-func processQuoteNode(node *parser.Node) (html string) {
-	content := ""
-	for _, child := range node.Children {
-		content += processContentNode(child)
+func (r *HTMLRenderer) Table(node *parser.Node, render RenderFunc) (html string) {
+	header := ""
+	body := ""
+	for _, row := range node.Children {
+		if row.Value[0] == 1 {
+			header += render(row)
+		} else {
+			body += render(row)
+		}
+	}
+	html = fmt.Sprintf("<table%s>\n<thead>\n%s</thead>\n", r.sourcePos(node), header)
+	if body != "" {
+		html += fmt.Sprintf("<tbody>\n%s</tbody>\n", body)
 	}
-	html = fmt.Sprintf("<q>%s</q>\n", content)
+	html += "</table>\n"
 	return
 }
 
-func processCodeBlockNode(node *parser.Node) (html string) {
-	content := string(node.Value)
-	html = fmt.Sprintf("<pre><code>%s</code></pre>", content)
+func (r *HTMLRenderer) TableRow(node *parser.Node, render RenderFunc) (html string) {
+	r.headerRow = node.Value[0] == 1
+	for _, cell := range node.Children {
+		html += render(cell)
+	}
+	r.headerRow = false
+	html = fmt.Sprintf("<tr%s>%s</tr>\n", r.sourcePos(node), html)
 	return
 }
 
-func processRichTextNode(node *parser.Node, tag string) (html string) {
-	content := processContentNode(node.Children[0])
+func (r *HTMLRenderer) TableCell(node *parser.Node, render RenderFunc) (html string) {
+	tag := "td"
+	if r.headerRow {
+		tag = "th"
+	}
+	align := ""
+	switch node.Value[0] {
+	case lexer.LeftAlignment:
+		align = " align='left'"
+	case lexer.CenterAlignment:
+		align = " align='center'"
+	case lexer.RightAlignment:
+		align = " align='right'"
+	}
+	return fmt.Sprintf("<%s%s>%s</%s>", tag, align, render(node.Children[0]), tag)
+}
+
+// sourcePos returns the data-sourcepos attribute of a block element like " data-sourcepos='1:1-2:5'",
+// which has the lines and columns of the first and the last character of the node, if SourcePos is on.
+func (r *HTMLRenderer) sourcePos(node *parser.Node) string {
+	if !r.options.SourcePos || node.Start.Line == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" data-sourcepos='%d:%d-%d:%d'", node.Start.Line, node.Start.Column, node.End.Line, node.End.Column-1)
+}
+
+func (r *HTMLRenderer) Text(node *parser.Node, render RenderFunc) string {
+	return escapeHTML(string(node.Value))
+}
+
+func (r *HTMLRenderer) LineBreak(node *parser.Node, render RenderFunc) string {
+	return "<br>\n"
+}
+
+func (r *HTMLRenderer) Italic(node *parser.Node, render RenderFunc) string {
+	return r.richText(node, "i", render)
+}
+
+func (r *HTMLRenderer) Bold(node *parser.Node, render RenderFunc) string {
+	return r.richText(node, "b", render)
+}
+
+func (r *HTMLRenderer) InlineCode(node *parser.Node, render RenderFunc) string {
+	return r.richText(node, "code", render)
+}
+
+func (r *HTMLRenderer) Strikethrough(node *parser.Node, render RenderFunc) string {
+	return r.richText(node, "del", render)
+}
+
+func (r *HTMLRenderer) richText(node *parser.Node, tag string, render RenderFunc) (html string) {
+	content := render(node.Children[0])
 	html = fmt.Sprintf("<%s>%s</%s>", tag, content, tag)
 	return
 }
 
-func processLinkNode(node *parser.Node) (html string) {
-	content := string(node.Children[0].Value)
+func (r *HTMLRenderer) Link(node *parser.Node, render RenderFunc) (html string) {
+	content := render(node.Children[0])
 	link := string(node.Value)
-	html = fmt.Sprintf("<a href='%s'>%s</a>", link, content)
+	attributes := ""
+	if r.policy != nil {
+		if !r.policy.allowURL(link) {
+			link = "#"
+		} else if isExternalURL(link) && r.policy.ExternalLinkRel != "" {
+			attributes = fmt.Sprintf(" rel='%s'", escapeAttribute(r.policy.ExternalLinkRel))
+		}
+	}
+	html = fmt.Sprintf("<a href='%s'%s>%s</a>", escapeAttribute(link), attributes, content)
 	return
 }
 
-func processImageNode(node *parser.Node) (html string) {
-	content := string(node.Children[0].Value)
+func (r *HTMLRenderer) Image(node *parser.Node, render RenderFunc) (html string) {
+	// The rendered text is already escaped except for the quote which closes the attribute.
+	content := strings.Replace(render(node.Children[0]), "'", "&#39;", -1)
 	link := string(node.Value)
-	html = fmt.Sprintf("<img src='%s' alt='%s'/>", link, content)
+	if r.policy != nil && !r.policy.allowURL(link) {
+		link = ""
+	}
+	html = fmt.Sprintf("<img src='%s' alt='%s'/>", escapeAttribute(link), content)
 	return
 }
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+var attributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&#39;")
+
+// escapeHTML escapes text and code, so that they are displayed as they are written.
+func escapeHTML(text string) string {
+	return htmlEscaper.Replace(text)
+}
+
+// escapeAttribute escapes the value of an attribute, it is safe to be quoted by both single and double quotes.
+func escapeAttribute(value string) string {
+	return attributeEscaper.Replace(value)
+}
+
+// plainText returns the text of the node without any formatting.
+func plainText(node *parser.Node) (text string) {
+	if node.Type == parser.TextNode {
+		return string(node.Value)
+	}
+	for _, child := range node.Children {
+		text += plainText(child)
+	}
+	return
+}
+
+// slugify turns the text into a form which can be used as an id or in a URL,
+// like GitHub does it: letters and digits are lowered, spaces become hyphens and other symbols are removed.
+func slugify(text string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c):
+			return unicode.ToLower(c)
+		case c == ' ':
+			return '-'
+		case c == '-' || c == '_':
+			return c
+		}
+		return -1
+	}, strings.TrimSpace(text))
+}
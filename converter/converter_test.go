@@ -0,0 +1,482 @@
+package converter
+
+import (
+	"encoding/json"
+	"html"
+	"io/ioutil"
+	"md2html/lexer"
+	"md2html/parser"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// codeOf returns the content of the first code block in the HTML.
+func codeOf(t *testing.T, html string) string {
+	start := strings.Index(html, "<pre><code")
+	end := strings.Index(html, "</code></pre>")
+	if start == -1 || end == -1 {
+		t.Fatalf("No code block found in %q", html)
+	}
+	code := html[start+len("<pre>") : end]
+	return code[strings.Index(code, ">")+1:]
+}
+
+var spanRegexp = regexp.MustCompile(`<span class='[\w-]+'>|</span>`)
+
+func TestCodeBlockRoundTrip(t *testing.T) {
+	// Every file contains source code of the language which is its name.
+	paths, err := filepath.Glob("../test/escape/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		source, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		language := strings.TrimSuffix(filepath.Base(path), ".txt")
+		markdown := "```" + language + "\n" + string(source) + "```\n"
+		for _, highlight := range []bool{false, true} {
+			options := DefaultOptions()
+			options.FullPage = false
+			options.Highlight = highlight
+			code := codeOf(t, ConvertWithOptions(markdown, options))
+			if highlight {
+				code = spanRegexp.ReplaceAllString(code, "")
+			}
+			if strings.ContainsAny(code, "<>") {
+				t.Errorf("%s: code block is not escaped: %q", path, code)
+			}
+			if got := html.UnescapeString(code); got != string(source) {
+				t.Errorf("%s: code block changed after conversion:\n%s", path, got)
+			}
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		markdown string
+		expected string
+	}{
+		{"a < b && c > d", "a &lt; b &amp;&amp; c &gt; d"},
+		{"`<br>`", "<code>&lt;br&gt;</code>"},
+		{"[x](https://a.com/?q='\"><script>)", "<a href='https://a.com/?q=&#39;&quot;&gt;&lt;script&gt;'>x</a>"},
+		{"![a' onerror='x](/a.png)", "<img src='/a.png' alt='a&#39; onerror=&#39;x'/>"},
+	}
+	for _, test := range tests {
+		if output := Convert(test.markdown, false); !strings.Contains(output, test.expected) {
+			t.Errorf("%q should contain %q, got %q", test.markdown, test.expected, output)
+		}
+	}
+}
+
+func TestConvertSafe(t *testing.T) {
+	tests := []struct {
+		markdown string
+		expected string
+	}{
+		{"[x](javascript:alert(1))", "<a href='#'>x</a>"},
+		{"[x](JavaScript:alert(1))", "<a href='#'>x</a>"},
+		{"[x](java\tscript:alert(1))", "<a href='#'>x</a>"},
+		{"![x](data:text/html;base64,PHNjcmlwdD4=)", "<img src='' alt='x'/>"},
+		{"[x](mailto:me@example.com)", "<a href='#'>x</a>"},
+		{"[x](/docs/index.html)", "<a href='/docs/index.html'>x</a>"},
+		{"[x](https://example.com)", "<a href='https://example.com' rel='nofollow noopener'>x</a>"},
+		{"[x](//example.com)", "<a href='//example.com' rel='nofollow noopener'>x</a>"},
+	}
+	for _, test := range tests {
+		if output := ConvertSafe(test.markdown, false, nil); !strings.Contains(output, test.expected) {
+			t.Errorf("%q should contain %q, got %q", test.markdown, test.expected, output)
+		}
+	}
+	policy := DefaultPolicy()
+	policy.AllowedSchemes = append(policy.AllowedSchemes, "mailto")
+	policy.ExternalLinkRel = ""
+	if output := ConvertSafe("[x](mailto:me@example.com) [y](https://example.com)", false, policy); !strings.Contains(output, "<a href='mailto:me@example.com'>x</a>") ||
+		!strings.Contains(output, "<a href='https://example.com'>y</a>") {
+		t.Errorf("The custom policy is not respected, got %q", output)
+	}
+}
+
+func TestConvertWithOptions(t *testing.T) {
+	options := DefaultOptions()
+	options.Title = "A & B"
+	options.CSS = "body { color: red; }"
+	options.HeadingIDs = true
+	var debug strings.Builder
+	options.Debug = &debug
+	output := ConvertWithOptions("# Hello, World!\n", options)
+	for _, expected := range []string{"<title>A &amp; B</title>", "body { color: red; }", "<h1 id='hello-world'>"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output should contain %q, got %q", expected, output)
+		}
+	}
+	if !strings.Contains(debug.String(), "TitleNode") {
+		t.Errorf("The AST should be printed, got %q", debug.String())
+	}
+
+	options = DefaultOptions()
+	options.FullPage = false
+	options.Extensions &^= lexer.TableExtension | lexer.StrikethroughExtension
+	output = ConvertWithOptions("| a |\n| - |\n\n~~b~~", options)
+	if strings.Contains(output, "<table>") || strings.Contains(output, "<del>") || strings.Contains(output, "<html>") {
+		t.Errorf("Disabled extensions should not be rendered, got %q", output)
+	}
+
+	options.FullPage = true
+	options.Template = "<main><style>%s</style>%s</main>"
+	if output = ConvertWithOptions("a", options); !strings.HasPrefix(output, "<main><style>\n.article") {
+		t.Errorf("The template without a title should still work, got %q", output)
+	}
+}
+
+func TestCodeBlockLanguage(t *testing.T) {
+	expected := "<pre><code class='language-go numbered'>"
+	if output := Convert("```go {.numbered}\npackage main\n```", false); !strings.Contains(output, expected) {
+		t.Errorf("Output should contain %q, got %q", expected, output)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		language string
+		code     string
+		expected []string
+	}{
+		{"go", "func main() { // <hi>\n\treturn \"a\" }", []string{
+			"<span class='hl-keyword'>func</span>",
+			"<span class='hl-function'>main</span>()",
+			"<span class='hl-comment'>// &lt;hi&gt;</span>",
+			"<span class='hl-string'>&quot;a&quot;</span>",
+		}},
+		{"py", "def f(): return None  # x", []string{"<span class='hl-keyword'>def</span>", "<span class='hl-literal'>None</span>"}},
+		{"ts", "let a: number = 1n", []string{"<span class='hl-keyword'>let</span>", "<span class='hl-type'>number</span>", "<span class='hl-number'>1n</span>"}},
+		{"bash", "echo $HOME", []string{"<span class='hl-builtin'>echo</span>", "<span class='hl-variable'>$HOME</span>"}},
+		{"json", `{"a": [1, true]}`, []string{"<span class='hl-key'>&quot;a&quot;</span>:", "<span class='hl-literal'>true</span>"}},
+		{"yaml", "a: b # c", []string{"<span class='hl-key'>a</span>:", "<span class='hl-comment'># c</span>"}},
+		{"c", "#include <stdio.h>", []string{"<span class='hl-meta'>#include</span> &lt;stdio.h&gt;"}},
+		{"sql", "select * from t", []string{"<span class='hl-keyword'>select</span>", "<span class='hl-keyword'>from</span>"}},
+		{"diff", "-a\n+b", []string{"<span class='hl-deleted'>-a</span>\n<span class='hl-inserted'>+b</span>"}},
+	}
+	for _, test := range tests {
+		html, ok := Highlight(test.code, test.language)
+		if !ok {
+			t.Errorf("Language %q should be supported", test.language)
+			continue
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(html, expected) {
+				t.Errorf("The highlighted %s code should contain %q, got %q", test.language, expected, html)
+			}
+		}
+	}
+	if _, ok := Highlight("a", "unknown"); ok {
+		t.Errorf("Unknown languages should not be highlighted")
+	}
+}
+
+func TestRegisterLanguage(t *testing.T) {
+	RegisterLanguage(NewRuleLexer(Rule{"keyword", words("hello")}), "greeting")
+	expected := "<span class='hl-keyword'>hello</span> world"
+	if output := Convert("```Greeting\nhello world\n```", false); !strings.Contains(output, expected) {
+		t.Errorf("Output should contain %q, got %q", expected, output)
+	}
+}
+
+func TestSourcePos(t *testing.T) {
+	options := DefaultOptions()
+	options.FullPage = false
+	options.SourcePos = true
+	output := ConvertWithOptions("# Title\n\n> Quote\n> text\n\n```\ncode\n```\n", options)
+	for _, expected := range []string{"<h1 data-sourcepos='1:1-1:7'>", "<blockquote data-sourcepos='3:1-4:6'>",
+		"<p data-sourcepos='3:3-4:6'>", "<pre data-sourcepos='6:1-8:3'>"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output should contain %q, got %q", expected, output)
+		}
+	}
+}
+
+func TestParagraph(t *testing.T) {
+	output := Convert("This is plain text.\nNot next line.  \nNext line.\n\nAnother paragraph.", false)
+	expected := "<p>This is plain text.\nNot next line.<br>\nNext line.</p>\n<p>Another paragraph.</p>\n"
+	if !strings.Contains(output, expected) {
+		t.Errorf("Output should contain %q, got %q", expected, output)
+	}
+}
+
+func TestEscapeAndEntity(t *testing.T) {
+	output := Convert("\\*not emphasis\\* &copy; &lt;b&gt; `*p = &x`", false)
+	expected := "<p>*not emphasis* © &lt;b&gt; <code>*p = &amp;x</code></p>"
+	if !strings.Contains(output, expected) {
+		t.Errorf("Output should contain %q, got %q", expected, output)
+	}
+}
+
+func TestTOC(t *testing.T) {
+	markdown := "Intro\n\n[TOC]\n\n# A\n## A.1\n### A.1.1\n## A.2\n# B\n"
+	options := DefaultOptions()
+	options.FullPage = false
+	options.TOC = true
+	options.TOCMaxLevel = 2
+	document := ConvertDocument(markdown, options)
+	if len(document.TOC) != 2 || len(document.TOC[0].Children) != 2 || len(document.TOC[0].Children[0].Children) != 0 {
+		t.Fatalf("The TOC should have A with A.1 and A.2, and B, got %+v", document.TOC)
+	}
+	if entry := document.TOC[0].Children[1]; entry.Title != "A.2" || entry.ID != "a2" || entry.Level != 2 {
+		t.Errorf("Wrong entry of A.2: %+v", entry)
+	}
+	expected := "<p>Intro</p>\n<nav class='toc'>\n<ul>\n<li><a href='#a'>A</a><ul>\n" +
+		"<li><a href='#a1'>A.1</a></li>\n<li><a href='#a2'>A.2</a></li>\n</ul>\n</li>\n<li><a href='#b'>B</a></li>\n</ul>\n</nav>\n<h1 id='a'>"
+	if !strings.Contains(document.HTML, expected) {
+		t.Errorf("Output should contain %q, got %q", expected, document.HTML)
+	}
+	// The table of contents is at the top if there is no marker.
+	output := ConvertWithOptions("# A\n", options)
+	if !strings.HasPrefix(output, "<div class='article'>\n<nav class='toc'>") {
+		t.Errorf("The TOC should be at the top, got %q", output)
+	}
+	// The headings in quotes and lists are in the table of contents as well.
+	document = ConvertDocument("# A\n\n> ## Quoted\n\n- item\n\n# B\n", options)
+	if len(document.TOC) != 2 || len(document.TOC[0].Children) != 1 || document.TOC[0].Children[0].ID != "quoted" {
+		t.Errorf("The TOC should have A with Quoted, and B, got %+v", document.TOC)
+	}
+}
+
+func TestHeadingIDs(t *testing.T) {
+	options := DefaultOptions()
+	options.FullPage = false
+	options.HeadingAnchors = true
+	output := ConvertWithOptions("# Intro\n## Intro\n## Intro\n## Custom {#intro-1}\n## What's new?\n## !!!\n## ?\n", options)
+	for _, expected := range []string{
+		"<h1 id='intro'>Intro<a class='anchor' href='#intro' aria-hidden='true'>¶</a></h1>",
+		"<h2 id='intro-2'>", "<h2 id='intro-3'>", "<h2 id='intro-1'>Custom<a", "<h2 id='whats-new'>",
+		"<h2 id='section'>!!!", "<h2 id='section-1'>?",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output should contain %q, got %q", expected, output)
+		}
+	}
+}
+
+func TestFrontMatter(t *testing.T) {
+	markdown := "---\ntitle: From <front> matter\ndescription: It's short.\ntags: [a, b]\n---\n# Hello\n"
+	document := ConvertDocument(markdown, DefaultOptions())
+	if document.Metadata["title"] != "From <front> matter" {
+		t.Errorf("The metadata should be returned, got %v", document.Metadata)
+	}
+	for _, expected := range []string{
+		"<title>From &lt;front&gt; matter</title>",
+		"<meta name='description' content='It&#39;s short.'>", "<meta name='keywords' content='a, b'>",
+	} {
+		if !strings.Contains(document.HTML, expected) {
+			t.Errorf("Output should contain %q, got %q", expected, document.HTML)
+		}
+	}
+	if strings.Contains(document.HTML, "<hr") || strings.Contains(document.HTML, "description:") {
+		t.Errorf("The front matter should not be rendered, got %q", document.HTML)
+	}
+
+	options := DefaultOptions()
+	options.Title = "Option"
+	options.DefaultTitle = "Default"
+	if output := ConvertWithOptions(markdown, options); !strings.Contains(output, "<title>Option</title>") {
+		t.Errorf("The title option should take precedence, got %q", output)
+	}
+	if output := ConvertWithOptions("# Hello\n", options); !strings.Contains(output, "<title>Option</title>") {
+		t.Errorf("The title option should be used, got %q", output)
+	}
+	options.Title = ""
+	if output := ConvertWithOptions("# Hello\n", options); !strings.Contains(output, "<title>Default</title>") {
+		t.Errorf("The default title should be used without front matter, got %q", output)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	markdown := "---\ntitle: Home\nauthor: Ann\n---\n# Hello\n"
+	output := ConvertWithOptions(markdown, DefaultOptions())
+	for _, expected := range []string{
+		"<!DOCTYPE html>", "<meta charset='utf-8'>", "<meta name='viewport'", "<title>Home</title>",
+		"<meta name='author' content='Ann'>", "<body>\n<div class='article'>\n<h1>Hello</h1>",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output should contain %q, got %q", expected, output)
+		}
+	}
+
+	options := DefaultOptions()
+	options.TOC = true
+	options.Vars = map[string]interface{}{"site": "<Site>"}
+	options.Template = `<title>{{.Title}} - {{.Vars.site}}</title><p>{{.Metadata.author}}</p>` +
+		`{{range .TOC}}<a href='#{{.ID}}'>{{.Title}}</a>{{end}}{{.Body}}`
+	output = ConvertWithOptions(markdown, options)
+	for _, expected := range []string{
+		"<title>Home - &lt;Site&gt;</title>", "<p>Ann</p>", "<a href='#hello'>Hello</a><div class='article'>",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output should contain %q, got %q", expected, output)
+		}
+	}
+
+	page, err := ParseTemplate("<main>{{.TOCHTML}}{{.Body}}</main>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.PageTemplate = page
+	if output = ConvertWithOptions(markdown, options); !strings.HasPrefix(output, "<main><nav class='toc'>") {
+		t.Errorf("The parsed template should be used, got %q", output)
+	}
+	if _, err := ParseTemplate("{{.Body"); err == nil {
+		t.Errorf("An invalid template should not be parsed")
+	}
+}
+
+func TestThemes(t *testing.T) {
+	for _, name := range []string{DefaultTheme, GitHubTheme, DarkTheme, AutoTheme} {
+		css, ok := LookupTheme(name)
+		if !ok || !strings.Contains(css, "@media print") {
+			t.Errorf("Theme %q should be registered with the print style sheet", name)
+		}
+	}
+	if css, _ := LookupTheme(AutoTheme); !strings.Contains(css, "@media (prefers-color-scheme: dark)") {
+		t.Errorf("The auto theme should switch to the dark colors, got %q", css)
+	}
+
+	options := DefaultOptions()
+	options.Theme = "GitHub"
+	if output := ConvertWithOptions("a", options); !strings.Contains(output, GitHubStyle) {
+		t.Errorf("The theme should be inlined, got %q", output)
+	}
+	RegisterTheme("plain", "p { margin: 0; }")
+	options.Theme = "plain"
+	if output := ConvertWithOptions("a", options); !strings.Contains(output, "<style>p { margin: 0; }</style>") {
+		t.Errorf("A registered theme should be used, got %q", output)
+	}
+
+	options.Stylesheets = []string{"/style.css", "print.css?v=1&x"}
+	output := ConvertWithOptions("a", options)
+	for _, expected := range []string{
+		"<link rel='stylesheet' href='/style.css'>", "<link rel='stylesheet' href='print.css?v=1&amp;x'>",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output should contain %q, got %q", expected, output)
+		}
+	}
+	if strings.Contains(output, "<style>") {
+		t.Errorf("The theme should not be inlined with linked style sheets, got %q", output)
+	}
+	options.CSS = "body { color: red; }"
+	if output = ConvertWithOptions("a", options); !strings.Contains(output, "<style>body { color: red; }</style>") {
+		t.Errorf("The CSS should still be inlined with linked style sheets, got %q", output)
+	}
+}
+
+// lazyImages overrides the rendering of images only.
+type lazyImages struct {
+	*HTMLRenderer
+}
+
+func (r lazyImages) Image(node *parser.Node, render RenderFunc) string {
+	return strings.Replace(r.HTMLRenderer.Image(node, render), "<img ", "<img loading='lazy' ", 1)
+}
+
+// classedLists overrides the rendering of lists and text.
+type classedLists struct {
+	*HTMLRenderer
+}
+
+func (r classedLists) List(node *parser.Node, render RenderFunc) string {
+	html := r.HTMLRenderer.List(node, render)
+	return html[:3] + " class='list'" + html[3:]
+}
+
+func (r classedLists) Text(node *parser.Node, render RenderFunc) string {
+	return strings.ToUpper(r.HTMLRenderer.Text(node, render))
+}
+
+func TestRenderer(t *testing.T) {
+	options := DefaultOptions()
+	options.FullPage = false
+	options.Renderer = func(html *HTMLRenderer) Renderer {
+		return lazyImages{html}
+	}
+	output := ConvertWithOptions("![a](a.png)\n\n- ![b](b.png)\n\n| ![c](c.png) |\n| - |\n", options)
+	if strings.Count(output, "<img loading='lazy' src=") != 3 {
+		t.Errorf("Every image should be rendered by the override, got %q", output)
+	}
+	if !strings.Contains(output, "<table>") || !strings.Contains(output, "<li>") {
+		t.Errorf("The other nodes should be rendered as HTML, got %q", output)
+	}
+
+	options.Renderer = func(html *HTMLRenderer) Renderer {
+		return classedLists{html}
+	}
+	output = ConvertWithOptions("- a\n    - [b](b.html)\n    - ![c](c.png)\n", options)
+	if strings.Count(output, " class='list'>") != 3 {
+		t.Errorf("Every list and sub-list should be rendered by the override, got %q", output)
+	}
+	for _, expected := range []string{">A<", ">B</a>", "alt='C'"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("The text of links and images should be rendered by the override, expected %q in %q", expected, output)
+		}
+	}
+
+	ast := parser.Parse("# Title\n\nText *with* [a link](b).\n")
+	expected := "<div class='article'>\n<h1>Title</h1>\n<p>Text <i>with</i> <a href='b'>a link</a>.</p>\n\n</div>"
+	if output = Render(NewHTMLRenderer(DefaultOptions()), ast); output != expected {
+		t.Errorf("The AST should be rendered as %q, got %q", expected, output)
+	}
+}
+
+func TestTransformers(t *testing.T) {
+	options := DefaultOptions()
+	options.FullPage = false
+	options.TOC = true
+	// Links to markdown files are rewritten to the converted pages.
+	rewriteLinks := parser.TransformerFunc(func(root *parser.Node) {
+		parser.Walk(root, func(node *parser.Node, entering bool) parser.WalkStatus {
+			if entering && node.Type == parser.LinkNode && strings.HasSuffix(string(node.Value), ".md") {
+				node.Value = []rune(strings.TrimSuffix(string(node.Value), ".md") + ".html")
+			}
+			return parser.WalkContinue
+		})
+	})
+	// A heading is injected at the top, it gets an id and is in the table of contents.
+	injectHeading := parser.TransformerFunc(func(root *parser.Node) {
+		title := &parser.Node{Type: parser.TitleNode, Value: []rune{1}, Children: []*parser.Node{{
+			Type: parser.ContentNode, Children: []*parser.Node{{Type: parser.TextNode, Value: []rune("Injected")}},
+		}}}
+		root.Children = append([]*parser.Node{title}, root.Children...)
+	})
+	options.Transformers = []parser.Transformer{rewriteLinks, injectHeading}
+	output := ConvertWithOptions("[Next](next.md) and [site](https://example.com)\n", options)
+	for _, expected := range []string{
+		"<a href='next.html'>Next</a>", "<a href='https://example.com'>site</a>",
+		"<a href='#injected'>Injected</a>", "<h1 id='injected'>Injected</h1>",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output should contain %q, got %q", expected, output)
+		}
+	}
+}
+
+func TestConvertAST(t *testing.T) {
+	var ast parser.Node
+	data := `{"type":"ArticleNode","metadata":{"title":"From JSON"},"children":[
+		{"type":"TitleNode","level":1,"children":[{"type":"ContentNode","children":[{"type":"TextNode","text":"Hello"}]}]},
+		{"type":"ParagraphNode","children":[{"type":"ContentNode","children":[
+			{"type":"LinkNode","url":"a.html","children":[{"type":"TextNode","text":"a"}]}]}]}]}`
+	if err := json.Unmarshal([]byte(data), &ast); err != nil {
+		t.Fatal(err)
+	}
+	options := DefaultOptions()
+	options.HeadingIDs = true
+	output := ConvertAST(&ast, options).HTML
+	for _, expected := range []string{"<title>From JSON</title>", "<h1 id='hello'>Hello</h1>", "<p><a href='a.html'>a</a></p>"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output should contain %q, got %q", expected, output)
+		}
+	}
+}
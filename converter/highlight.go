@@ -0,0 +1,119 @@
+package converter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// CodeToken is a piece of source code, its Class is empty if it should not be highlighted.
+type CodeToken struct {
+	Class string
+	Text  string
+}
+
+// CodeLexer splits source code into tokens for highlighting.
+type CodeLexer interface {
+	Tokenize(code string) []CodeToken
+}
+
+// Rule matches a kind of token, like keywords or strings, with a regular expression.
+// If the expression has a capturing group, only the text of the group gets the class,
+// which makes it possible to check what follows the token, e.g. the colon after a key.
+type Rule struct {
+	Class   string
+	Pattern string
+}
+
+// RuleLexer is a CodeLexer which tries its rules in order at every position, the first matched one wins.
+type RuleLexer struct {
+	classes  []string
+	patterns []*regexp.Regexp
+}
+
+// NewRuleLexer compiles the rules, it panics if any pattern is not a valid regular expression.
+func NewRuleLexer(rules ...Rule) *RuleLexer {
+	lexer := &RuleLexer{}
+	for _, rule := range rules {
+		lexer.classes = append(lexer.classes, rule.Class)
+		lexer.patterns = append(lexer.patterns, regexp.MustCompile(`\A(?:`+rule.Pattern+`)`))
+	}
+	return lexer
+}
+
+func (lexer *RuleLexer) Tokenize(code string) (tokens []CodeToken) {
+	// plain is the start of the text which is not matched by any rule yet.
+	plain := 0
+	for pos := 0; pos < len(code); {
+		matched := false
+		for i, pattern := range lexer.patterns {
+			match := pattern.FindStringSubmatchIndex(code[pos:])
+			if match == nil || match[1] == 0 {
+				continue
+			}
+			matched = true
+			start, end := pos, pos+match[1]
+			if len(match) > 2 && match[2] >= 0 {
+				start, end = pos+match[2], pos+match[3]
+			}
+			pos += match[1]
+			if lexer.classes[i] == "" || start == end {
+				break
+			}
+			if start > plain {
+				tokens = append(tokens, CodeToken{Text: code[plain:start]})
+			}
+			tokens = append(tokens, CodeToken{Class: lexer.classes[i], Text: code[start:end]})
+			plain = end
+			break
+		}
+		if !matched {
+			_, size := utf8.DecodeRuneInString(code[pos:])
+			pos += size
+		}
+	}
+	if plain < len(code) {
+		tokens = append(tokens, CodeToken{Text: code[plain:]})
+	}
+	return
+}
+
+var codeLexers = make(map[string]CodeLexer)
+var codeLexersLock sync.RWMutex
+
+// RegisterLanguage registers the lexer for the languages with the given names, such as "js" and "javascript".
+// Names are case insensitive, and an existing lexer of the same name is replaced.
+func RegisterLanguage(lexer CodeLexer, names ...string) {
+	codeLexersLock.Lock()
+	defer codeLexersLock.Unlock()
+	for _, name := range names {
+		codeLexers[strings.ToLower(name)] = lexer
+	}
+}
+
+// LookupLanguage returns the lexer registered for the language, or nil if there is none.
+func LookupLanguage(name string) CodeLexer {
+	codeLexersLock.RLock()
+	defer codeLexersLock.RUnlock()
+	return codeLexers[strings.ToLower(name)]
+}
+
+// Highlight returns the escaped code with its tokens wrapped in spans like <span class='hl-keyword'>,
+// it returns false if no lexer is registered for the language.
+func Highlight(code, language string) (html string, ok bool) {
+	lexer := LookupLanguage(language)
+	if lexer == nil {
+		return "", false
+	}
+	var builder strings.Builder
+	for _, token := range lexer.Tokenize(code) {
+		if token.Class == "" {
+			builder.WriteString(escapeHTML(token.Text))
+		} else {
+			_, _ = fmt.Fprintf(&builder, "<span class='hl-%s'>%s</span>", escapeAttribute(token.Class), escapeHTML(token.Text))
+		}
+	}
+	return builder.String(), true
+}
@@ -0,0 +1,160 @@
+package converter
+
+import (
+	"strings"
+)
+
+// words returns a pattern matching any of the words.
+func words(list string) string {
+	return `(?:` + strings.Join(strings.Fields(list), "|") + `)\b`
+}
+
+// Patterns shared by many languages, a number pattern should be followed by its suffixes and \b.
+const (
+	identifierPattern    = `[A-Za-z_$][\w$]*`
+	numberPattern        = `(?:0[xX][0-9a-fA-F_]+|0[bB][01_]+|\d[\d_]*(?:\.\d+)?(?:[eE][+-]?\d+)?)`
+	doubleQuotedPattern  = `"(?:\\.|[^"\\\n])*"`
+	singleQuotedPattern  = `'(?:\\.|[^'\\\n])*'`
+	lineCommentPattern   = `//[^\n]*`
+	blockCommentPattern  = `/\*[\s\S]*?(?:\*/|\z)`
+	hashCommentPattern   = `#[^\n]*`
+	functionCallPattern  = `([A-Za-z_$][\w$]*)\s*\(`
+	cStyleCommentPattern = lineCommentPattern + `|` + blockCommentPattern
+)
+
+func init() {
+	RegisterLanguage(NewRuleLexer(
+		Rule{"comment", cStyleCommentPattern},
+		Rule{"string", "`[^`]*`"},
+		Rule{"string", doubleQuotedPattern},
+		Rule{"string", singleQuotedPattern},
+		Rule{"keyword", words(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var`)},
+		Rule{"type", words(`any bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64
+			rune string uint uint8 uint16 uint32 uint64 uintptr`)},
+		Rule{"literal", words(`true false nil iota`)},
+		Rule{"builtin", words(`append cap close complex copy delete imag len make new panic print println real recover`)},
+		Rule{"function", functionCallPattern},
+		Rule{"", identifierPattern},
+		Rule{"number", numberPattern + `i?\b`},
+	), "go", "golang")
+
+	RegisterLanguage(NewRuleLexer(
+		Rule{"comment", hashCommentPattern},
+		Rule{"string", `(?i:[rbuf]{0,2})(?:"""[\s\S]*?(?:"""|\z)|'''[\s\S]*?(?:'''|\z))`},
+		Rule{"string", `(?i:[rbuf]{0,2})(?:` + doubleQuotedPattern + `|` + singleQuotedPattern + `)`},
+		Rule{"meta", `@[\w.]+`},
+		Rule{"keyword", words(`and as assert async await break class continue def del elif else except finally for
+			from global if import in is lambda nonlocal not or pass raise return try while with yield match case`)},
+		Rule{"literal", words(`True False None self`)},
+		Rule{"builtin", words(`abs all any bool bytes dict dir enumerate filter float format getattr hasattr int
+			isinstance len list map max min object open print range repr reversed set setattr sorted str sum super
+			tuple type zip`)},
+		Rule{"function", functionCallPattern},
+		Rule{"", identifierPattern},
+		Rule{"number", numberPattern + `j?\b`},
+	), "python", "py", "python3")
+
+	javaScriptRules := []Rule{
+		{"comment", cStyleCommentPattern},
+		{"string", "`(?:\\\\.|[^`\\\\])*`"},
+		{"string", doubleQuotedPattern},
+		{"string", singleQuotedPattern},
+		{"keyword", words(`async await break case catch class const continue debugger default delete do else export
+			extends finally for from function get if import in instanceof let new of return set static super switch
+			this throw try typeof var void while with yield`)},
+		{"literal", words(`true false null undefined NaN Infinity`)},
+	}
+	typeScriptRules := append([]Rule{
+		{"keyword", words(`abstract as declare enum implements interface keyof namespace private protected public
+			readonly type`)},
+		{"type", words(`any boolean never number object string symbol unknown void`)},
+	}, javaScriptRules...)
+	commonRules := []Rule{
+		{"function", functionCallPattern},
+		{"", identifierPattern},
+		{"number", numberPattern + `n?\b`},
+	}
+	RegisterLanguage(NewRuleLexer(append(javaScriptRules, commonRules...)...), "javascript", "js", "jsx", "mjs")
+	RegisterLanguage(NewRuleLexer(append(typeScriptRules, commonRules...)...), "typescript", "ts", "tsx")
+
+	RegisterLanguage(NewRuleLexer(
+		Rule{"meta", `#![^\n]*`},
+		Rule{"comment", `#[^\n]*`},
+		Rule{"string", doubleQuotedPattern},
+		Rule{"string", `'[^']*'`},
+		Rule{"variable", `\$\{[^}\n]*\}|\$\w+|\$[@#?$!*0-9-]`},
+		Rule{"keyword", words(`if then else elif fi for in while until do done case esac function return local
+			export select break continue`)},
+		Rule{"builtin", words(`alias cd echo eval exec exit printf pwd read set shift source test trap unset`)},
+		Rule{"", `[\w.-]+`},
+	), "shell", "sh", "bash", "zsh")
+
+	RegisterLanguage(NewRuleLexer(
+		Rule{"key", `(` + doubleQuotedPattern + `)\s*:`},
+		Rule{"string", doubleQuotedPattern},
+		Rule{"literal", words(`true false null`)},
+		Rule{"number", `-?\d+(?:\.\d+)?(?:[eE][+-]?\d+)?`},
+	), "json")
+
+	RegisterLanguage(NewRuleLexer(
+		Rule{"comment", `#[^\n]*`},
+		Rule{"meta", `(?:---|\.\.\.)(?:\s|\z)`},
+		Rule{"key", `([\w.-]+|` + doubleQuotedPattern + `|` + singleQuotedPattern + `)[ \t]*:(?:[ \t]|\n|\z)`},
+		Rule{"string", doubleQuotedPattern},
+		Rule{"string", `'(?:''|[^'])*'`},
+		Rule{"variable", `[&*][\w-]+`},
+		Rule{"type", `![\w!/.-]*`},
+		Rule{"literal", words(`true false null yes no on off True False Null`) + `|~`},
+		Rule{"number", `-?\d[\d_]*(?:\.\d+)?(?:[eE][+-]?\d+)?\b`},
+		Rule{"", `[\w.-]+`},
+	), "yaml", "yml")
+
+	cKeywords := `auto break case char const continue default do double else enum extern float for goto if inline int
+		long register restrict return short signed sizeof static struct switch typedef union unsigned void volatile
+		while`
+	cRules := []Rule{
+		{"comment", cStyleCommentPattern},
+		{"meta", `#[ \t]*\w+`},
+		{"string", doubleQuotedPattern},
+		{"string", singleQuotedPattern},
+		{"type", words(`bool size_t ssize_t int8_t int16_t int32_t int64_t uint8_t uint16_t uint32_t uint64_t FILE`)},
+		{"literal", words(`NULL true false nullptr this`)},
+	}
+	cEndRules := []Rule{
+		{"function", functionCallPattern},
+		{"", identifierPattern},
+		{"number", numberPattern + `[uUlLfF]*\b`},
+	}
+	RegisterLanguage(NewRuleLexer(append(append(cRules, Rule{"keyword", words(cKeywords)}), cEndRules...)...),
+		"c", "h")
+	RegisterLanguage(NewRuleLexer(append(append(cRules, Rule{"keyword", words(cKeywords + ` catch class constexpr
+		delete explicit friend mutable namespace new noexcept operator override private protected public template
+		throw try typename using virtual`)}), cEndRules...)...), "cpp", "c++", "cc", "hpp")
+
+	RegisterLanguage(NewRuleLexer(
+		Rule{"comment", `--[^\n]*|` + blockCommentPattern},
+		Rule{"string", `'(?:''|[^'])*'`},
+		Rule{"", `"[^"\n]*"|` + "`[^`\\n]*`"},
+		Rule{"keyword", `(?i)` + words(`add all alter and as asc begin between by case check column commit constraint
+			create cross database default delete desc distinct drop else end exists foreign from full group having if
+			in index inner insert into is join key left like limit not null offset on or order outer primary
+			references returning right rollback select set table then transaction union unique update using values
+			view when where with`)},
+		Rule{"type", `(?i)` + words(`bigint blob boolean char date datetime decimal double float int integer json
+			numeric real serial smallint text time timestamp varchar`)},
+		Rule{"literal", `(?i)` + words(`true false`)},
+		Rule{"function", functionCallPattern},
+		Rule{"", identifierPattern},
+		Rule{"number", numberPattern + `\b`},
+	), "sql")
+
+	// Every rule of diff matches a whole line, so that the rules always start at the beginning of a line.
+	RegisterLanguage(NewRuleLexer(
+		Rule{"meta", `(?:diff|index|\+\+\+|---)[^\n]*`},
+		Rule{"section", `@@[^\n]*`},
+		Rule{"inserted", `\+[^\n]*`},
+		Rule{"deleted", `-[^\n]*`},
+		Rule{"", `[^\n]+`},
+	), "diff", "patch")
+}
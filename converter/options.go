@@ -0,0 +1,69 @@
+package converter
+
+import (
+	"html/template"
+	"io"
+	"md2html/lexer"
+	"md2html/parser"
+)
+
+// Options controls how markdown is converted to HTML.
+type Options struct {
+	// FullPage wraps the article in a full HTML page, otherwise only the article is returned.
+	FullPage bool
+	// Title is the title of the full page, it takes precedence over the title in the front matter.
+	Title string
+	// DefaultTitle is the title of the full page if there is no Title and the front matter has no title.
+	DefaultTitle string
+	// CSS is the style sheet of the full page, the style sheet of Theme is used if it is empty and there are no Stylesheets.
+	CSS string
+	// Theme is the name of a theme, such as "github", "dark" or "auto", Style is used if it is empty, see RegisterTheme.
+	Theme string
+	// Stylesheets are the URLs of the style sheets linked by the full page instead of inlining the style sheet.
+	Stylesheets []string
+	// Template is the full page in the syntax of html/template, HtmlTemplate is used if it is empty.
+	// A template in the old format of fmt with %s for the style sheet and the article still works.
+	Template string
+	// PageTemplate is the parsed page template, it takes precedence over Template, see ParseTemplate.
+	PageTemplate *template.Template
+	// Vars are the custom variables of the page template, which are .Vars in the template.
+	Vars map[string]interface{}
+	// Extensions are the enabled syntax extensions.
+	Extensions lexer.Extension
+	// Safe turns on safe mode for untrusted markdown, see ConvertSafe.
+	Safe bool
+	// Policy is the policy of safe mode, DefaultPolicy is used if it is nil.
+	Policy *Policy
+	// Highlight highlights the code blocks whose language is registered, see RegisterLanguage.
+	Highlight bool
+	// HeadingIDs gives every heading a unique id generated from its text like GitHub does,
+	// an id can also be written after a heading like `# Title {#id}`.
+	HeadingIDs bool
+	// HeadingAnchors adds a ¶ link to every heading, which is shown when the heading is hovered.
+	// The headings get ids as if HeadingIDs is on.
+	HeadingAnchors bool
+	// TOC adds a table of contents at the [TOC] marker, or at the top of the article if there is no marker.
+	// The headings get ids as if HeadingIDs is on.
+	TOC bool
+	// TOCMinLevel and TOCMaxLevel are the levels of the headings in the table of contents, 0 means 1 and 6.
+	TOCMinLevel int
+	TOCMaxLevel int
+	// SourcePos adds a data-sourcepos attribute with the lines and columns in the markdown to every block element.
+	SourcePos bool
+	// Transformers rewrite the AST in order before it is rendered, the ids of the headings are assigned after them.
+	Transformers []parser.Transformer
+	// Renderer returns the Renderer of the article, it gets the HTMLRenderer of the conversion to embed or wrap,
+	// such as a Renderer which only overrides Image. The HTMLRenderer is used if it is nil.
+	Renderer func(html *HTMLRenderer) Renderer
+	// Debug is where the AST is printed to, nothing is printed if it is nil.
+	Debug io.Writer
+}
+
+// DefaultOptions returns the options Convert uses for a full page.
+func DefaultOptions() Options {
+	return Options{
+		FullPage:   true,
+		Extensions: lexer.DefaultExtensions,
+		Highlight:  true,
+	}
+}
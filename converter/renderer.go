@@ -0,0 +1,100 @@
+package converter
+
+import (
+	"md2html/parser"
+)
+
+// RenderFunc renders a node through the Renderer of the conversion, a Renderer calls it to render the children of a node.
+type RenderFunc func(node *parser.Node) string
+
+// Renderer renders an AST into an output format, it has a method for every type of node.
+// Every method returns the output of the node, and renders the children it needs with render.
+// Since the children go back through the Renderer, a Renderer which embeds HTMLRenderer and overrides
+// some of its methods changes the rendering of those types of nodes everywhere in the tree.
+type Renderer interface {
+	Article(node *parser.Node, render RenderFunc) string
+	Title(node *parser.Node, render RenderFunc) string
+	DividingLine(node *parser.Node, render RenderFunc) string
+	Paragraph(node *parser.Node, render RenderFunc) string
+	// Content renders the inline nodes of a paragraph, a heading, a list item or a table cell.
+	Content(node *parser.Node, render RenderFunc) string
+	// List renders the placeholder ListNode holding the items of a list.
+	List(node *parser.Node, render RenderFunc) string
+	// ListItem renders a ListNode which is an item, its first child is its content.
+	ListItem(node *parser.Node, render RenderFunc) string
+	Quote(node *parser.Node, render RenderFunc) string
+	CodeBlock(node *parser.Node, render RenderFunc) string
+	Table(node *parser.Node, render RenderFunc) string
+	TableRow(node *parser.Node, render RenderFunc) string
+	TableCell(node *parser.Node, render RenderFunc) string
+	Text(node *parser.Node, render RenderFunc) string
+	LineBreak(node *parser.Node, render RenderFunc) string
+	Italic(node *parser.Node, render RenderFunc) string
+	Bold(node *parser.Node, render RenderFunc) string
+	InlineCode(node *parser.Node, render RenderFunc) string
+	Strikethrough(node *parser.Node, render RenderFunc) string
+	Link(node *parser.Node, render RenderFunc) string
+	Image(node *parser.Node, render RenderFunc) string
+}
+
+// Render renders the node and its children with the renderer.
+func Render(renderer Renderer, node *parser.Node) string {
+	var render RenderFunc
+	render = func(node *parser.Node) string {
+		switch node.Type {
+		case parser.ArticleNode:
+			return renderer.Article(node, render)
+		case parser.TitleNode:
+			return renderer.Title(node, render)
+		case parser.DividingLineNode:
+			return renderer.DividingLine(node, render)
+		case parser.ParagraphNode:
+			return renderer.Paragraph(node, render)
+		case parser.ContentNode:
+			return renderer.Content(node, render)
+		case parser.ListNode:
+			if isListItem(node) {
+				return renderer.ListItem(node, render)
+			}
+			return renderer.List(node, render)
+		case parser.QuoteNode:
+			return renderer.Quote(node, render)
+		case parser.CodeBlockNode:
+			return renderer.CodeBlock(node, render)
+		case parser.TableNode:
+			return renderer.Table(node, render)
+		case parser.TableRowNode:
+			return renderer.TableRow(node, render)
+		case parser.TableCellNode:
+			return renderer.TableCell(node, render)
+		case parser.TextNode:
+			return renderer.Text(node, render)
+		case parser.LineBreakNode:
+			return renderer.LineBreak(node, render)
+		case parser.ItalicNode:
+			return renderer.Italic(node, render)
+		case parser.BoldNode:
+			return renderer.Bold(node, render)
+		case parser.InlineCodeNode:
+			return renderer.InlineCode(node, render)
+		case parser.StrikethroughNode:
+			return renderer.Strikethrough(node, render)
+		case parser.LinkNode:
+			return renderer.Link(node, render)
+		case parser.ImageNode:
+			return renderer.Image(node, render)
+		}
+		return ""
+	}
+	return render(node)
+}
+
+// isListItem reports whether the ListNode is an item rather than the placeholder holding the items of a list.
+func isListItem(node *parser.Node) bool {
+	return len(node.Value) != 0 && node.Value[0] != -1
+}
+
+// isOrderedList reports whether the list whose first item is the node is an ordered list.
+func isOrderedList(item *parser.Node) bool {
+	return int(item.Value[0])%2 != 0
+}
@@ -0,0 +1,76 @@
+package converter
+
+import (
+	"strings"
+)
+
+// Policy controls how untrusted markdown is rendered in safe mode.
+type Policy struct {
+	// AllowedSchemes are the URL schemes which links and images may use, such as "https" or "mailto".
+	// Relative URLs have no scheme and are always allowed.
+	AllowedSchemes []string
+	// ExternalLinkRel is the rel attribute added to links pointing to other sites, it is omitted if empty.
+	ExternalLinkRel string
+}
+
+// DefaultPolicy returns a policy which only allows http and https URLs.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		AllowedSchemes:  []string{"http", "https"},
+		ExternalLinkRel: "nofollow noopener",
+	}
+}
+
+// ConvertSafe converts untrusted markdown according to the policy, DefaultPolicy is used if it is nil.
+// Links and images with a disallowed URL scheme such as "javascript:" or "data:" are neutralized.
+// Raw HTML in the markdown is never rendered, it is always escaped as text.
+func ConvertSafe(markdown string, fullPage bool, policy *Policy) (html string) {
+	options := DefaultOptions()
+	options.FullPage = fullPage
+	options.Safe = true
+	options.Policy = policy
+	return ConvertWithOptions(markdown, options)
+}
+
+func (policy *Policy) allowURL(url string) bool {
+	scheme := urlScheme(url)
+	if scheme == "" {
+		return true
+	}
+	for _, allowed := range policy.AllowedSchemes {
+		if strings.ToLower(allowed) == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanURL removes the whitespaces and control characters which are ignored by browsers,
+// otherwise "java\tscript:" would sneak through as a relative URL.
+func cleanURL(url string) string {
+	return strings.Map(func(c rune) rune {
+		if c <= ' ' || c == 0x7f {
+			return -1
+		}
+		return c
+	}, url)
+}
+
+// urlScheme returns the lower case scheme of the URL, it returns "" for relative URLs.
+func urlScheme(url string) string {
+	url = cleanURL(url)
+	i := strings.IndexAny(url, ":/?#")
+	if i <= 0 || url[i] != ':' {
+		return ""
+	}
+	return strings.ToLower(url[:i])
+}
+
+// isExternalURL reports whether the URL has a host, such as "https://example.com" or "//example.com".
+func isExternalURL(url string) bool {
+	url = cleanURL(url)
+	if scheme := urlScheme(url); scheme != "" {
+		url = url[len(scheme)+1:]
+	}
+	return strings.HasPrefix(url, "//")
+}
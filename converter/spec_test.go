@@ -0,0 +1,136 @@
+package converter
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var updateSpec = flag.Bool("update", false, "update the list of passing spec examples")
+
+// specDirectory holds the spec examples in the JSON format of the CommonMark spec, one file per spec,
+// and the list of examples which are known to pass.
+const specDirectory = "../test/spec"
+
+const passingFile = specDirectory + "/passing.txt"
+
+// specExample is an example of a spec, the fields are the ones of spec.json generated by the CommonMark spec tools.
+type specExample struct {
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+// specResult counts the passing examples of a section.
+type specResult struct {
+	name   string
+	passed int
+	total  int
+}
+
+var (
+	articleRegexp       = regexp.MustCompile(`^\s*<div class='article'>|</div>\s*$`)
+	attributeRegexp     = regexp.MustCompile(`(\s[\w-]+)='([^']*)'`)
+	emptyAttrRegexp     = regexp.MustCompile(`(\s[\w-]+)=""`)
+	selfClosingRegexp   = regexp.MustCompile(`\s*/>`)
+	spaceBetweenRegexp  = regexp.MustCompile(`>\s+<`)
+	emphasisTagRegexp   = regexp.MustCompile(`<(/?)em>`)
+	strongTagRegexp     = regexp.MustCompile(`<(/?)strong>`)
+	whitespaceEndRegexp = regexp.MustCompile(`^\s+|\s+$`)
+)
+
+// normalizeHTML removes the differences of HTML which do not change how it is displayed,
+// such as quotes of attributes, self-closing tags and whitespaces between tags.
+// <em> and <strong> are treated the same as <i> and <b>, which are emitted by the converter.
+func normalizeHTML(html string) string {
+	html = articleRegexp.ReplaceAllString(html, "")
+	html = attributeRegexp.ReplaceAllString(html, `$1="$2"`)
+	html = emptyAttrRegexp.ReplaceAllString(html, "$1")
+	html = selfClosingRegexp.ReplaceAllString(html, ">")
+	html = spaceBetweenRegexp.ReplaceAllString(html, "><")
+	html = emphasisTagRegexp.ReplaceAllString(html, "<${1}i>")
+	html = strongTagRegexp.ReplaceAllString(html, "<${1}b>")
+	return whitespaceEndRegexp.ReplaceAllString(html, "")
+}
+
+func loadSpec(t *testing.T, path string) (examples []specExample) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &examples); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return
+}
+
+func loadPassing(t *testing.T) map[string]bool {
+	passing := map[string]bool{}
+	data, err := ioutil.ReadFile(passingFile)
+	if err != nil {
+		t.Logf("No passing examples are known: %v", err)
+		return passing
+	}
+	for _, id := range strings.Fields(string(data)) {
+		passing[id] = true
+	}
+	return passing
+}
+
+// TestSpec converts the examples of the specs and reports the pass rate of every section.
+// It fails if an example listed in the passing file fails, run it with -update after fixing more examples.
+func TestSpec(t *testing.T) {
+	paths, err := filepath.Glob(specDirectory + "/*.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("There should be spec files in %s", specDirectory)
+	}
+	known := loadPassing(t)
+	var passed []string
+	for _, path := range paths {
+		spec := strings.TrimSuffix(filepath.Base(path), ".json")
+		var results []*specResult
+		sections := map[string]*specResult{}
+		for _, example := range loadSpec(t, path) {
+			result := sections[example.Section]
+			if result == nil {
+				result = &specResult{name: example.Section}
+				sections[example.Section] = result
+				results = append(results, result)
+			}
+			result.total++
+			id := fmt.Sprintf("%s:%d", spec, example.Example)
+			expected := normalizeHTML(example.HTML)
+			actual := normalizeHTML(Convert(example.Markdown, false))
+			if expected == actual {
+				result.passed++
+				passed = append(passed, id)
+				if !known[id] {
+					t.Logf("%s (%s) passes now", id, example.Section)
+				}
+			} else if known[id] {
+				t.Errorf("%s (%s) used to pass\nmarkdown: %q\nexpected: %q\nactual:   %q",
+					id, example.Section, example.Markdown, expected, actual)
+			}
+		}
+		passedTotal, total := 0, 0
+		for _, result := range results {
+			t.Logf("%s: %-40s %3d/%3d", spec, result.name, result.passed, result.total)
+			passedTotal += result.passed
+			total += result.total
+		}
+		t.Logf("%s: %-40s %3d/%3d (%.1f%%)", spec, "total", passedTotal, total, float64(passedTotal)*100/float64(total))
+	}
+	if *updateSpec {
+		sort.Strings(passed)
+		if err := ioutil.WriteFile(passingFile, []byte(strings.Join(passed, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
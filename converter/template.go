@@ -1,9 +1,153 @@
 package converter
 
-var HtmlTemplate = `<html>
-<head><style>%s</style></head>
-<body style="">%s</body>
-</html>`
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"strings"
+)
+
+// HtmlTemplate is the default page template in the syntax of html/template, it is executed with a TemplateData.
+var HtmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset='utf-8'>
+<meta name='viewport' content='width=device-width, initial-scale=1'>
+<title>{{.Title}}</title>
+{{range .Stylesheets}}<link rel='stylesheet' href='{{.}}'>
+{{end}}{{.Meta}}{{with .CSS}}<style>{{.}}</style>{{end}}
+</head>
+<body>
+{{.Body}}
+</body>
+</html>
+`
+
+// TemplateData is what a page template is executed with.
+type TemplateData struct {
+	// Body is the article.
+	Body template.HTML
+	// Title is the Title option, the title in the front matter, or the DefaultTitle option.
+	Title string
+	// Metadata is the front matter of the document, nil if it has none.
+	Metadata map[string]interface{}
+	// TOC is the table of contents, it is only built if the TOC option is on.
+	TOC []*TOCEntry
+	// CSS is the inlined style sheet, it is empty if there are only Stylesheets.
+	CSS template.CSS
+	// Stylesheets are the URLs of the linked style sheets.
+	Stylesheets []string
+	// Meta is the <meta> tags of the description, the author and the keywords in the front matter.
+	Meta template.HTML
+	// Vars are the custom variables given by the Vars option.
+	Vars map[string]interface{}
+}
+
+// TOCHTML returns the table of contents as it is rendered in the article, which is empty if there is none.
+func (data *TemplateData) TOCHTML() template.HTML {
+	if len(data.TOC) == 0 {
+		return ""
+	}
+	return template.HTML(processTOC(data.TOC))
+}
+
+func newTemplateData(options Options, document *Document) *TemplateData {
+	data := &TemplateData{
+		Body:        template.HTML(document.HTML),
+		Title:       options.Title,
+		Metadata:    document.Metadata,
+		TOC:         document.TOC,
+		CSS:         template.CSS(options.CSS),
+		Stylesheets: options.Stylesheets,
+		Meta:        template.HTML(metaTags(document.Metadata)),
+		Vars:        options.Vars,
+	}
+	if data.CSS == "" && len(data.Stylesheets) == 0 {
+		data.CSS = template.CSS(themeStyle(options.Theme))
+	}
+	if data.Title == "" {
+		if value, ok := document.Metadata["title"]; ok && value != nil {
+			data.Title = fmt.Sprint(value)
+		} else {
+			data.Title = options.DefaultTitle
+		}
+	}
+	return data
+}
+
+// ParseTemplate parses a page template written in the syntax of html/template, see TemplateData for what it can use.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("page").Parse(text)
+}
+
+// isLegacyTemplate reports whether the template is written in the format of fmt rather than html/template.
+func isLegacyTemplate(text string) bool {
+	return !strings.Contains(text, "{{")
+}
+
+// fillTemplate puts the article into the page template.
+func fillTemplate(options Options, data *TemplateData) string {
+	page := options.PageTemplate
+	if page == nil {
+		text := options.Template
+		if text == "" {
+			text = HtmlTemplate
+		}
+		if isLegacyTemplate(text) {
+			return fillLegacyTemplate(text, data)
+		}
+		var err error
+		if page, err = ParseTemplate(text); err != nil {
+			log.Println("Warning: the default template is used because the template is invalid:", err)
+			page = template.Must(ParseTemplate(HtmlTemplate))
+		}
+	}
+	var buffer bytes.Buffer
+	if err := page.Execute(&buffer, data); err != nil {
+		log.Println("Error: failed to fill the template:", err)
+		return string(data.Body)
+	}
+	return buffer.String()
+}
+
+// fillLegacyTemplate fills a template in the format of fmt, %[1]s is the style sheet, %[2]s is the article,
+// %[3]s is the title and %[4]s is the <meta> tags. A template with only two %s has no title.
+func fillLegacyTemplate(text string, data *TemplateData) string {
+	switch {
+	case strings.Contains(text, "%[4]s"):
+		return fmt.Sprintf(text, data.CSS, data.Body, escapeHTML(data.Title), data.Meta)
+	case strings.Contains(text, "%[3]s"):
+		return fmt.Sprintf(text, data.CSS, data.Body, escapeHTML(data.Title))
+	}
+	return fmt.Sprintf(text, data.CSS, data.Body)
+}
+
+// metaTags returns the <meta> tags of the description, the author and the keywords in the metadata.
+// The keywords are the "keywords" or the "tags" of the metadata, which can be a list or a string.
+func metaTags(metadata map[string]interface{}) (html string) {
+	keywords, ok := metadata["keywords"]
+	if !ok {
+		keywords = metadata["tags"]
+	}
+	if list, ok := keywords.([]interface{}); ok {
+		var words []string
+		for _, word := range list {
+			words = append(words, fmt.Sprint(word))
+		}
+		keywords = strings.Join(words, ", ")
+	}
+	for _, meta := range []struct {
+		name  string
+		value interface{}
+	}{{"description", metadata["description"]}, {"author", metadata["author"]}, {"keywords", keywords}} {
+		switch meta.value.(type) {
+		case string, int, float64:
+			html += fmt.Sprintf("<meta name='%s' content='%s'>", meta.name, escapeAttribute(fmt.Sprint(meta.value)))
+		}
+	}
+	return
+}
 
 var Style = `
 .article {
@@ -108,16 +252,13 @@ var Style = `
     border: 1px solid #bbb;
 }
 
-.article q {
-    margin-left: 0;
+.article blockquote {
+    margin: 0 0 1.25rem;
     padding: 0 1em;
+    color: #666;
     border-left: 5px solid #ddd;
 }
 
-.article q::before, .article q::after {
-    content: none;
-}
-
 .article code {
     color: #343d46;
     padding: .065em .4em;
@@ -136,12 +277,87 @@ var Style = `
 	color: white;
 }
 
+.article pre .hl-comment {
+    color: #a7adba;
+    font-style: italic;
+}
+
+.article pre .hl-keyword {
+    color: #c594c5;
+}
+
+.article pre .hl-string {
+    color: #99c794;
+}
+
+.article pre .hl-number,
+.article pre .hl-literal {
+    color: #f99157;
+}
+
+.article pre .hl-type {
+    color: #fac863;
+}
+
+.article pre .hl-builtin,
+.article pre .hl-function,
+.article pre .hl-key {
+    color: #6699cc;
+}
+
+.article pre .hl-meta,
+.article pre .hl-section {
+    color: #5fb3b3;
+}
+
+.article pre .hl-variable {
+    color: #ec5f67;
+}
+
+.article pre .hl-inserted {
+    color: #99c794;
+    background-color: rgba(153, 199, 148, 0.1);
+}
+
+.article pre .hl-deleted {
+    color: #ec5f67;
+    background-color: rgba(236, 95, 103, 0.1);
+}
+
 .article ol {
     text-decoration: none;
     padding-inline-start: 40px;
     margin-bottom: 1.25rem;
 }
 
+.article .anchor {
+    margin-left: 0.3em;
+    color: #ccc;
+    font-weight: normal;
+    visibility: hidden;
+}
+
+.article h1:hover .anchor,
+.article h2:hover .anchor,
+.article h3:hover .anchor,
+.article h4:hover .anchor,
+.article h5:hover .anchor,
+.article h6:hover .anchor {
+    visibility: visible;
+}
+
+.article .toc {
+    margin: 1em 0;
+    padding: 0.5em 1em;
+    border-left: 4px solid #ddd;
+}
+
+.article .toc ul {
+    margin: 0;
+    padding-left: 1.2em;
+    list-style: none;
+}
+
 .article input[type='checkbox'] {
 	margin-right: 8px;
 	font-size: larger;
@@ -0,0 +1,361 @@
+package converter
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The built-in themes, "default" is Style, "auto" follows the color scheme of the system.
+const (
+	DefaultTheme = "default"
+	GitHubTheme  = "github"
+	DarkTheme    = "dark"
+	AutoTheme    = "auto"
+)
+
+// themeStyle returns the style sheet of the theme, Style is used for an empty or unknown name.
+func themeStyle(name string) string {
+	if name == "" {
+		return Style + PrintStyle
+	}
+	css, ok := LookupTheme(name)
+	if !ok {
+		log.Printf("Warning: there is no theme %q, the default one is used.", name)
+		return Style + PrintStyle
+	}
+	return css
+}
+
+var themes = make(map[string]string)
+var themesLock sync.RWMutex
+
+// RegisterTheme registers the style sheet as the theme with the given name,
+// names are case insensitive, and an existing theme of the same name is replaced.
+func RegisterTheme(name, css string) {
+	themesLock.Lock()
+	defer themesLock.Unlock()
+	themes[strings.ToLower(name)] = css
+}
+
+// LookupTheme returns the style sheet of the theme, it returns false if there is no such theme.
+func LookupTheme(name string) (css string, ok bool) {
+	themesLock.RLock()
+	defer themesLock.RUnlock()
+	css, ok = themes[strings.ToLower(name)]
+	return
+}
+
+// ThemeNames returns the names of the registered themes in alphabetical order.
+func ThemeNames() (names []string) {
+	themesLock.RLock()
+	defer themesLock.RUnlock()
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func init() {
+	RegisterTheme(DefaultTheme, Style+PrintStyle)
+	RegisterTheme(GitHubTheme, GitHubStyle+PrintStyle)
+	RegisterTheme(DarkTheme, Style+DarkStyle+PrintStyle)
+	RegisterTheme(AutoTheme, Style+"\n@media (prefers-color-scheme: dark) {"+DarkStyle+"}\n"+PrintStyle)
+}
+
+// DarkStyle only has the colors of the dark theme, it is applied on top of Style.
+var DarkStyle = `
+body {
+    background-color: #1e2227;
+}
+
+.article {
+    color: #d7dae0;
+}
+
+.article a,
+.article a:hover {
+    color: #61afef;
+}
+
+.article table td,
+.article table th {
+    border-color: #4b5263;
+}
+
+.article blockquote {
+    color: #9da5b4;
+    border-left-color: #4b5263;
+}
+
+.article code {
+    color: #e5c07b;
+}
+
+.article pre {
+    background-color: #282c34;
+}
+
+.article .anchor {
+    color: #5c6370;
+}
+
+.article .toc {
+    border-left-color: #4b5263;
+}
+`
+
+// GitHubStyle looks like the markdown files rendered by GitHub.
+var GitHubStyle = `
+.article {
+    margin: auto;
+    max-width: 980px;
+    padding: 32px;
+    color: #1f2328;
+    font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", "Noto Sans", Helvetica, Arial, sans-serif;
+    font-size: 16px;
+    line-height: 1.5;
+    overflow-wrap: break-word;
+}
+
+.article a {
+    color: #0969da;
+    text-decoration: none;
+}
+
+.article a:hover {
+    text-decoration: underline;
+}
+
+.article h1,
+.article h2,
+.article h3,
+.article h4,
+.article h5,
+.article h6 {
+    margin-top: 24px;
+    margin-bottom: 16px;
+    font-weight: 600;
+    line-height: 1.25;
+}
+
+.article h1,
+.article h2 {
+    padding-bottom: .3em;
+    border-bottom: 1px solid #d1d9e0;
+}
+
+.article h1 {
+    font-size: 2em;
+}
+
+.article h2 {
+    font-size: 1.5em;
+}
+
+.article h3 {
+    font-size: 1.25em;
+}
+
+.article h5 {
+    font-size: .875em;
+}
+
+.article h6 {
+    font-size: .85em;
+    color: #59636e;
+}
+
+.article p,
+.article blockquote,
+.article ul,
+.article ol,
+.article table,
+.article pre {
+    margin-top: 0;
+    margin-bottom: 16px;
+}
+
+.article hr {
+    height: .25em;
+    margin: 24px 0;
+    padding: 0;
+    border: 0;
+    background-color: #d1d9e0;
+}
+
+.article img {
+    max-width: 100%;
+}
+
+.article blockquote {
+    padding: 0 1em;
+    color: #59636e;
+    border-left: .25em solid #d1d9e0;
+}
+
+.article ul,
+.article ol {
+    padding-left: 2em;
+}
+
+.article table {
+    display: block;
+    width: max-content;
+    max-width: 100%;
+    overflow: auto;
+    border-collapse: collapse;
+    border-spacing: 0;
+}
+
+.article table th {
+    font-weight: 600;
+}
+
+.article table td,
+.article table th {
+    padding: 6px 13px;
+    border: 1px solid #d1d9e0;
+}
+
+.article table tr:nth-child(2n) {
+    background-color: #f6f8fa;
+}
+
+.article code {
+    padding: .2em .4em;
+    font-family: ui-monospace, SFMono-Regular, "SF Mono", Menlo, Consolas, monospace;
+    font-size: 85%;
+    background-color: rgba(129, 139, 152, .12);
+    border-radius: 6px;
+}
+
+.article pre {
+    overflow: auto;
+    padding: 16px;
+    font-size: 85%;
+    line-height: 1.45;
+    background-color: #f6f8fa;
+    border-radius: 6px;
+}
+
+.article pre code {
+    padding: 0;
+    font-size: 100%;
+    background-color: transparent;
+}
+
+.article pre .hl-comment {
+    color: #59636e;
+}
+
+.article pre .hl-keyword,
+.article pre .hl-deleted {
+    color: #cf222e;
+}
+
+.article pre .hl-string,
+.article pre .hl-inserted {
+    color: #0a3069;
+}
+
+.article pre .hl-number,
+.article pre .hl-literal,
+.article pre .hl-builtin,
+.article pre .hl-key,
+.article pre .hl-meta,
+.article pre .hl-variable {
+    color: #0550ae;
+}
+
+.article pre .hl-type,
+.article pre .hl-function,
+.article pre .hl-section {
+    color: #8250df;
+}
+
+.article .anchor {
+    margin-left: .3em;
+    color: #d1d9e0;
+    visibility: hidden;
+}
+
+.article h1:hover .anchor,
+.article h2:hover .anchor,
+.article h3:hover .anchor,
+.article h4:hover .anchor,
+.article h5:hover .anchor,
+.article h6:hover .anchor {
+    visibility: visible;
+}
+
+.article .toc ul {
+    margin: 0;
+    padding-left: 1.2em;
+    list-style: none;
+}
+
+.article input[type='checkbox'] {
+    margin: 0 .2em .25em -1.4em;
+    vertical-align: middle;
+}
+`
+
+// PrintStyle is added to the built-in themes, it prints the article in black and white without the page decorations.
+var PrintStyle = `
+@media print {
+    body {
+        background-color: white;
+    }
+
+    .article {
+        max-width: none;
+        padding: 0;
+        color: black;
+        font-size: 12pt;
+    }
+
+    .article a {
+        color: black;
+        text-decoration: underline;
+    }
+
+    .article a[href^='http']::after {
+        content: " (" attr(href) ")";
+        font-size: 90%;
+    }
+
+    .article .anchor {
+        display: none;
+    }
+
+    .article pre,
+    .article blockquote,
+    .article table,
+    .article img {
+        page-break-inside: avoid;
+    }
+
+    .article pre {
+        white-space: pre-wrap;
+        background-color: white;
+        border: 1px solid #999;
+    }
+
+    .article pre code,
+    .article pre span {
+        color: black;
+    }
+
+    .article h1,
+    .article h2,
+    .article h3,
+    .article h4,
+    .article h5,
+    .article h6 {
+        page-break-after: avoid;
+    }
+}
+`
@@ -0,0 +1,113 @@
+package converter
+
+import (
+	"fmt"
+	"md2html/parser"
+	"strings"
+)
+
+// TOCEntry is a heading in the table of contents.
+type TOCEntry struct {
+	// Level is the level of the heading, from 1 to 6.
+	Level int
+	// Title is the text of the heading without formatting.
+	Title string
+	// ID is the id of the heading, which is the target of the link in the table of contents.
+	ID string
+	// Children are the headings under this heading.
+	Children []*TOCEntry
+}
+
+// tocMarker is the paragraph which is replaced by the table of contents.
+const tocMarker = "[TOC]"
+
+// BuildTOC returns the table of contents made of the headings of the article whose levels are from minLevel to maxLevel,
+// including the headings in quotes and lists.
+// The ids of the headings should have been assigned, see Options.HeadingIDs.
+func BuildTOC(article *parser.Node, minLevel, maxLevel int) (toc []*TOCEntry) {
+	var stack []*TOCEntry
+	forEachHeading(article, func(node *parser.Node) {
+		level := int(node.Value[0])
+		if level < minLevel || level > maxLevel {
+			return
+		}
+		entry := &TOCEntry{Level: level, Title: plainText(node), ID: node.Attributes["id"]}
+		for len(stack) != 0 && stack[len(stack)-1].Level >= level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			toc = append(toc, entry)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+		stack = append(stack, entry)
+	})
+	return
+}
+
+// assignHeadingIDs stores a unique id in the attributes of every heading under the node,
+// ids are generated like GitHub does, and a number is appended to an id which is already used, e.g. "title-1".
+// A heading whose id would be empty gets "section" instead.
+// The ids written in the markdown like `# Title {#id}` are kept.
+func assignHeadingIDs(root *parser.Node) {
+	used := map[string]bool{}
+	forEachHeading(root, func(node *parser.Node) {
+		if id := node.Attributes["id"]; id != "" {
+			used[id] = true
+		}
+	})
+	forEachHeading(root, func(node *parser.Node) {
+		if node.Attributes["id"] != "" {
+			return
+		}
+		id := slugify(plainText(node))
+		if id == "" {
+			// A heading without letters or digits still needs an id to be linked to.
+			id = "section"
+		}
+		unique := id
+		for n := 1; used[unique]; n++ {
+			unique = fmt.Sprintf("%s-%d", id, n)
+		}
+		used[unique] = true
+		if node.Attributes == nil {
+			node.Attributes = map[string]string{}
+		}
+		node.Attributes["id"] = unique
+	})
+}
+
+// forEachHeading calls f with every heading under the node in order.
+func forEachHeading(root *parser.Node, f func(node *parser.Node)) {
+	parser.Walk(root, func(node *parser.Node, entering bool) parser.WalkStatus {
+		if entering && node.Type == parser.TitleNode {
+			f(node)
+			return parser.WalkSkipChildren
+		}
+		return parser.WalkContinue
+	})
+}
+
+// isTOCMarker reports whether the node is a paragraph of only [TOC].
+func isTOCMarker(node *parser.Node) bool {
+	return node.Type == parser.ParagraphNode && strings.TrimSpace(plainText(node)) == tocMarker
+}
+
+func processTOC(toc []*TOCEntry) (html string) {
+	if len(toc) == 0 {
+		return
+	}
+	return fmt.Sprintf("<nav class='toc'>\n%s</nav>\n", processTOCEntries(toc))
+}
+
+func processTOCEntries(entries []*TOCEntry) (html string) {
+	for _, entry := range entries {
+		children := ""
+		if len(entry.Children) != 0 {
+			children = processTOCEntries(entry.Children)
+		}
+		html += fmt.Sprintf("<li><a href='#%s'>%s</a>%s</li>\n", escapeAttribute(entry.ID), escapeHTML(entry.Title), children)
+	}
+	return fmt.Sprintf("<ul>\n%s</ul>\n", html)
+}
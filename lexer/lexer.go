@@ -2,6 +2,10 @@ package lexer
 
 import (
 	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strings"
 	"unicode"
 )
 
@@ -29,6 +33,10 @@ const (
 	LinkHeadToken
 	ImageHeadToken
 	LinkBodyToken
+	TableRowToken
+	TableCellToken
+	TableDelimiterToken
+	FrontMatterToken
 )
 
 var TokenTypeName = []string{
@@ -53,137 +61,458 @@ var TokenTypeName = []string{
 	"LinkHeadToken",
 	"ImageHeadToken",
 	"LinkBodyToken",
+	"TableRowToken",
+	"TableCellToken",
+	"TableDelimiterToken",
+	"FrontMatterToken",
+}
+
+// The alignments of table columns, they are the values of a TableDelimiterToken.
+const (
+	NoAlignment rune = iota
+	LeftAlignment
+	CenterAlignment
+	RightAlignment
+)
+
+// Extension is a set of syntax extensions which are not part of the basic markdown.
+type Extension uint
+
+const (
+	TableExtension Extension = 1 << iota
+	StrikethroughExtension
+	TaskListExtension
+	// FrontMatterExtension treats the metadata fenced by --- (YAML) or +++ (TOML) at the start of the document
+	// as a FrontMatterToken.
+	FrontMatterExtension
+)
+
+const DefaultExtensions = TableExtension | StrikethroughExtension | TaskListExtension | FrontMatterExtension
+
+// Position is a location in the markdown document.
+type Position struct {
+	// Line is the line number starting from 1.
+	Line int `json:"line"`
+	// Column is the byte offset in the line starting from 1.
+	Column int `json:"column"`
+	// Offset is the byte offset in the document starting from 0.
+	Offset int `json:"offset"`
 }
 
 type Token struct {
 	Type  TokenType
 	Value []rune
+	// Info is the info string following the opening fence of a CodeBlockToken, such as "go",
+	// or the format of a FrontMatterToken, which is "yaml" or "toml".
+	Info []rune
+	// Start is where the token starts, and End is right after where it ends.
+	Start Position
+	End   Position
+	// offsets are the byte offsets of the runes of the Value in the document,
+	// they are only set for tokens whose Value can be tokenized by a nested Lexer.
+	offsets []int
 }
 
-var input []rune
-var pos = 0
-var lastTokenType = NewlineToken
-var tokenQueue []Token
+// Lexer turns a markdown document into a stream of tokens.
+// Every document should get its own Lexer, so that different documents can be tokenized concurrently.
+type Lexer struct {
+	// Extensions are the enabled syntax extensions, they should not be changed after the first token is read.
+	Extensions Extension
 
+	input []rune
+	// offsets are the byte offsets of the runes of the input in the document, with the end of the input at last.
+	offsets []int
+	// lines are the byte offsets where the lines of the document start.
+	lines         []int
+	pos           int
+	lastTokenType TokenType
+	tokenQueue    []Token
+	// Tokens which should be emitted right after the one nextToken returns.
+	pendingTokens []Token
+	// The column alignments of the table being tokenized, nil if we are not in a table.
+	tableAlignments    []rune
+	tableDelimiterNext bool
+	// nested is set if the input is a part of the document, such as the content of a quote.
+	nested bool
+}
+
+// New returns a Lexer reading from the given markdown.
+func New(markdown string) *Lexer {
+	l := &Lexer{
+		Extensions:    DefaultExtensions,
+		input:         []rune(markdown),
+		lines:         []int{0},
+		pos:           0,
+		lastTokenType: NewlineToken,
+		tokenQueue:    nil,
+	}
+	for offset, c := range markdown {
+		l.offsets = append(l.offsets, offset)
+		if c == '\n' {
+			l.lines = append(l.lines, offset+1)
+		}
+	}
+	l.offsets = append(l.offsets, len(markdown))
+	return l
+}
+
+// Nested returns a Lexer for the content of a QuoteToken or a TableCellToken read from l,
+// the positions of its tokens are still in the document of l.
+func (l *Lexer) Nested(token Token) *Lexer {
+	nested := &Lexer{
+		Extensions:    l.Extensions,
+		input:         token.Value,
+		offsets:       token.offsets,
+		lines:         l.lines,
+		lastTokenType: NewlineToken,
+		nested:        true,
+	}
+	if token.Type == TableCellToken {
+		nested.lastTokenType = TextToken
+	}
+	return nested
+}
+
+// position returns the position of the rune at i in the document.
+func (l *Lexer) position(i int) Position {
+	offset := l.offsets[i]
+	// The number of lines which start before or at the offset.
+	line := sort.Search(len(l.lines), func(n int) bool {
+		return l.lines[n] > offset
+	})
+	return Position{Line: line, Column: offset - l.lines[line-1] + 1, Offset: offset}
+}
+
+// The default lexer used by Tokenize and NextToken.
+var defaultLexer = New("")
+
+// Tokenize resets the default lexer with the given markdown.
+// It is kept for compatibility and is not safe for concurrent use, use New instead.
 func Tokenize(markdown string) {
-	input = []rune(markdown)
-	pos = 0
-	lastTokenType = NewlineToken
-	tokenQueue = nil
+	defaultLexer = New(markdown)
 }
 
-func nextIsSameTo(c rune) bool {
-	if pos+1 >= len(input) {
+// NextToken returns the next token of the default lexer.
+func NextToken() Token {
+	return defaultLexer.NextToken()
+}
+
+func (l *Lexer) nextIsSameTo(c rune) bool {
+	if l.pos+1 >= len(l.input) {
 		return false
 	}
-	return c == input[pos+1]
+	return c == l.input[l.pos+1]
 }
 
-func isSpaceBehind() bool {
-	if pos+1 >= len(input) {
+func (l *Lexer) isSpaceBehind() bool {
+	if l.pos+1 >= len(l.input) {
 		return true
 	}
-	return input[pos+1] == ' '
+	return l.input[l.pos+1] == ' '
 }
 
-func isNumDotSpace() bool {
-	if unicode.IsDigit(input[pos]) {
-		return len(input) > pos+2 && input[pos+1] == '.' && input[pos+2] == ' '
+func (l *Lexer) isNumDotSpace() bool {
+	if unicode.IsDigit(l.input[l.pos]) {
+		return len(l.input) > l.pos+2 && l.input[l.pos+1] == '.' && l.input[l.pos+2] == ' '
 	}
 	return false
 }
 
-func isTaskSymbol() (yes, completed bool) {
+func (l *Lexer) isTaskSymbol() (yes, completed bool) {
 	yes = false
-	if len(input) > pos+2 && input[pos] == '[' {
-		completed = input[pos+1] != ' '
-		if input[pos+2] == ']' {
+	if l.Extensions&TaskListExtension != 0 && len(l.input) > l.pos+2 && l.input[l.pos] == '[' {
+		completed = l.input[l.pos+1] != ' '
+		if l.input[l.pos+2] == ']' {
 			yes = true
 		}
 	}
 	return
 }
 
-func countSymbol(c rune) (n int) {
+func (l *Lexer) countSymbol(c rune) (n int) {
 	n = 0
-	for pos+n < len(input) && input[pos+n] == c {
+	for l.pos+n < len(l.input) && l.input[l.pos+n] == c {
 		n++
 	}
 	return
 }
 
-func getCodeBlockStartEnd() (start, end int) {
-	start = pos
-	end = pos
-	// Skip the language name.
-	for ; start < len(input) && input[start] != '\n'; start++ {
+// getFencedCodeBlock returns the info string and the content of the code block fenced by at least three backticks or tildes,
+// it returns false if there is no fence at the current position.
+// The closing fence should be at least as long as the opening one, otherwise the code block runs to the end of the input.
+func (l *Lexer) getFencedCodeBlock() (info, content []rune, ok bool) {
+	c := l.input[l.pos]
+	n := l.countSymbol(c)
+	if n < 3 {
+		return nil, nil, false
 	}
-	start++
-	for end = start; end+2 < len(input); end++ {
-		if input[end] == '`' && input[end+1] == '`' && input[end+2] == '`' {
+	end := l.lineEnd(l.pos)
+	info = trimSpace(l.input[l.pos+n : end])
+	for _, r := range info {
+		if c == '`' && r == '`' {
+			return nil, nil, false
+		}
+	}
+	// The content is indented as much as the opening fence, e.g. in a list item.
+	indent := indentation(l.input[l.lineStart(l.pos):l.pos])
+	closed := false
+	l.pos = len(l.input)
+	for start := end + 1; start < len(l.input); start = end + 1 {
+		end = l.lineEnd(start)
+		line := l.input[start:end]
+		if isClosingFence(line, c, n) {
+			closed = true
+			l.pos = end
+			break
+		}
+		content = append(content, trimNewline(stripIndentation(line, indent))...)
+		content = append(content, '\n')
+	}
+	if !closed {
+		log.Println("Warning: code block is not closed, it runs to the end of the input.")
+	}
+	return info, content, true
+}
+
+func isClosingFence(line []rune, c rune, n int) bool {
+	line = trimSpace(line)
+	if len(line) < n {
+		return false
+	}
+	for _, r := range line {
+		if r != c {
+			return false
+		}
+	}
+	return true
+}
+
+// WithoutFrontMatter returns a new Lexer of the same input which does not treat its start as front matter,
+// it is used when the front matter is not valid, so that its lines are tokenized as markdown.
+func (l *Lexer) WithoutFrontMatter() *Lexer {
+	lexer := New(string(l.input))
+	lexer.Extensions = l.Extensions &^ FrontMatterExtension
+	return lexer
+}
+
+// getFrontMatter returns the format and the content of the front matter at the start of the document,
+// which is fenced by --- for YAML, or +++ for TOML. YAML front matter can also be closed by "...".
+// It returns false if the first line is not a fence or the front matter is not closed.
+func (l *Lexer) getFrontMatter() (format, content []rune, ok bool) {
+	end := l.lineEnd(0)
+	fence := string(trimSpace(l.input[:end]))
+	switch fence {
+	case "---":
+		format = []rune("yaml")
+	case "+++":
+		format = []rune("toml")
+	default:
+		return nil, nil, false
+	}
+	for start := end + 1; start < len(l.input); start = end + 1 {
+		end = l.lineEnd(start)
+		line := string(trimSpace(l.input[start:end]))
+		if line == fence || fence == "---" && line == "..." {
+			content = l.input[l.lineEnd(0)+1 : start]
+			l.pos = end
+			return format, content, true
+		}
+	}
+	return nil, nil, false
+}
+
+// getIndentedCodeBlock returns the content of the code block indented by at least four spaces or a tab,
+// it returns false if there is no such code block starting at the current line.
+func (l *Lexer) getIndentedCodeBlock() (content []rune, ok bool) {
+	first := l.input[l.pos:l.lineEnd(l.pos)]
+	if len(trimSpace(first)) == 0 || indentation(first) < 4 || !l.canStartIndentedCode() {
+		return nil, false
+	}
+	var lines []rune
+	for start := l.pos; start < len(l.input); start = l.lineEnd(start) + 1 {
+		end := l.lineEnd(start)
+		line := l.input[start:end]
+		blank := len(trimSpace(line)) == 0
+		if !blank && indentation(line) < 4 {
+			break
+		}
+		lines = append(lines, trimNewline(stripIndentation(line, 4))...)
+		lines = append(lines, '\n')
+		// Blank lines are only kept when they are followed by more code.
+		if !blank {
+			content = append(content, lines...)
+			lines = nil
+			l.pos = end
+		}
+	}
+	return content, true
+}
+
+// canStartIndentedCode reports whether an indented line at the current position is a code block.
+// An indented line can not interrupt a paragraph, and it belongs to the list item above it if there is one.
+func (l *Lexer) canStartIndentedCode() bool {
+	if l.pos == 0 {
+		return true
+	}
+	previous := trimSpace(l.input[l.lineStart(l.pos-1) : l.pos-1])
+	if len(previous) != 0 && previous[0] != '#' {
+		return false
+	}
+	for end := l.pos - 1; end > 0; {
+		start := l.lineStart(end - 1)
+		line := l.input[start : end-1]
+		if len(trimSpace(line)) != 0 && indentation(line) < 4 {
+			return !isListItem(line)
+		}
+		end = start
+	}
+	return true
+}
+
+// lineStart returns the position where the line containing pos starts.
+func (l *Lexer) lineStart(pos int) (start int) {
+	for start = pos; start > 0 && l.input[start-1] != '\n'; start-- {
+	}
+	return
+}
+
+// indentation returns the width of the leading whitespaces of the line, a tab is as wide as four spaces.
+func indentation(line []rune) (width int) {
+	for _, c := range line {
+		if c == ' ' {
+			width++
+		} else if c == '\t' {
+			width += 4 - width%4
+		} else {
 			break
 		}
 	}
 	return
 }
 
-func NextToken() (token Token) {
-	if len(tokenQueue) != 0 {
-		token = tokenQueue[0]
-		tokenQueue = tokenQueue[1:]
+// stripIndentation removes the leading whitespaces of the line up to the given width.
+func stripIndentation(line []rune, width int) []rune {
+	for len(line) > 0 && width > 0 && (line[0] == ' ' || line[0] == '\t') {
+		if line[0] == '\t' {
+			width -= 4
+		} else {
+			width--
+		}
+		line = line[1:]
+	}
+	return line
+}
+
+func trimNewline(line []rune) []rune {
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+func isListItem(line []rune) bool {
+	line = trimSpace(line)
+	if len(line) > 1 && (line[0] == '-' || line[0] == '+' || line[0] == '*') && line[1] == ' ' {
+		return true
+	}
+	i := 0
+	for i < len(line) && unicode.IsDigit(line[i]) {
+		i++
+	}
+	return i > 0 && i+1 < len(line) && (line[i] == '.' || line[i] == ')') && line[i+1] == ' '
+}
+
+// NextToken returns the next token, EofToken is returned after the input is exhausted.
+func (l *Lexer) NextToken() (token Token) {
+	if len(l.tokenQueue) != 0 {
+		token = l.tokenQueue[0]
+		l.tokenQueue = l.tokenQueue[1:]
 	} else {
-		textToken, otherToken := nextToken()
+		textToken, otherToken := l.nextToken()
 		if len(textToken.Value) != 0 {
 			token = textToken
-			tokenQueue = append(tokenQueue, otherToken)
+			l.tokenQueue = append(l.tokenQueue, otherToken)
 		} else {
 			token = otherToken
 		}
-		lastTokenType = otherToken.Type
+		l.tokenQueue = append(l.tokenQueue, l.pendingTokens...)
+		l.pendingTokens = nil
+		l.lastTokenType = otherToken.Type
 	}
 	return
 }
 
-func nextToken() (textToken, otherToken Token) {
+func (l *Lexer) nextToken() (textToken, otherToken Token) {
 	textToken.Type = TextToken
+	textStart, textEnd, otherStart := l.pos, l.pos, l.pos
+	defer func() {
+		// The markers of a list item at the end of the input may skip a space which does not exist.
+		if l.pos > len(l.input) {
+			l.pos = len(l.input)
+		}
+		textToken.Start, textToken.End = l.position(textStart), l.position(textEnd)
+		// The positions of a token are kept if they are already set, such as the ones of a code span.
+		if otherToken.End.Line == 0 {
+			otherToken.Start, otherToken.End = l.position(otherStart), l.position(l.pos)
+		}
+	}()
 	for {
-		if pos >= len(input) {
+		otherStart = l.pos
+		if l.pos >= len(l.input) {
 			otherToken.Type = EofToken
 			return
 		}
-		c := input[pos]
-		if len(textToken.Value) == 0 && (lastTokenType == NewlineToken || lastTokenType == TabToken) {
+		c := l.input[l.pos]
+		if l.pos == 0 && l.lastTokenType == NewlineToken && !l.nested && l.Extensions&FrontMatterExtension != 0 {
+			if format, content, ok := l.getFrontMatter(); ok {
+				otherToken.Type = FrontMatterToken
+				otherToken.Value = content
+				otherToken.Info = format
+				return
+			}
+		}
+		if len(textToken.Value) == 0 && l.lastTokenType == NewlineToken {
+			if content, ok := l.getIndentedCodeBlock(); ok {
+				otherToken.Type = CodeBlockToken
+				otherToken.Value = content
+				return
+			}
+			if l.lexTable(&otherToken) {
+				return
+			}
+		}
+		if len(textToken.Value) == 0 && (l.lastTokenType == NewlineToken || l.lastTokenType == TabToken) {
 			switch c {
 			case '#':
-				n := countSymbol(c)
+				n := l.countSymbol(c)
 				otherToken.Type = TitleToken
 				otherToken.Value = append(otherToken.Value, rune(n))
-				pos += n
-				if input[pos] == ' ' {
-					pos++
+				l.pos += n
+				if l.pos < len(l.input) && l.input[l.pos] == ' ' {
+					l.pos++
 				}
 				return
 			case '\t':
 				otherToken.Type = TabToken
-				pos++
+				l.pos++
 				return
 			case '\n':
 				otherToken.Type = NewlineToken
-				pos++
+				l.pos++
 				return
 			case '-':
 				fallthrough
 			case '+':
 				fallthrough
 			case '*':
-				if isSpaceBehind() {
+				if l.isSpaceBehind() {
 					otherToken.Type = UnorderedListToken
-					pos += 2
-					yes, completed := isTaskSymbol()
+					l.pos += 2
+					yes, completed := l.isTaskSymbol()
 					if yes {
-						pos += 2
-						if isSpaceBehind() {
-							pos += 2
+						l.pos += 2
+						if l.isSpaceBehind() {
+							l.pos += 2
 							if completed {
 								otherToken.Type = CompletedTaskToken
 							} else {
@@ -191,132 +520,511 @@ func nextToken() (textToken, otherToken Token) {
 							}
 							return
 						}
-						pos -= 2
+						l.pos -= 2
 					}
 					return
 				} else { // Consider if this is a dividing line
-					if nextIsSameTo(c) {
-						pos++
-						if nextIsSameTo(c) {
-							pos += 2
+					if l.nextIsSameTo(c) {
+						l.pos++
+						if l.nextIsSameTo(c) {
+							l.pos += 2
 							otherToken.Type = DividingLineToken
 							return
 						}
-						pos--
+						l.pos--
 					}
 				}
 			case '>':
-				if isSpaceBehind() {
-					otherToken.Type = QuoteToken
-					pos += 2
-					return
-				}
+				otherToken.Type = QuoteToken
+				otherToken.Value, otherToken.offsets = l.getQuoteContent()
+				return
+			case '~':
+				fallthrough
 			case '`':
-				if nextIsSameTo(c) {
-					pos++
-					if nextIsSameTo(c) {
-						pos += 2
-						otherToken.Type = CodeBlockToken
-						start, end := getCodeBlockStartEnd()
-						otherToken.Value = input[start:end]
-						pos = end + 3
-						return
-					}
-					pos--
+				if info, content, ok := l.getFencedCodeBlock(); ok {
+					otherToken.Type = CodeBlockToken
+					otherToken.Value = content
+					otherToken.Info = info
+					return
 				}
 			case '\r':
 				fallthrough
 			case ' ':
-				n := countSymbol(c)
+				n := l.countSymbol(c)
 				if n >= 2 {
-					pos += n
+					l.pos += n
 					otherToken.Type = TabToken
 					return
 				} else {
-					pos++
+					l.pos++
 				}
 			}
-			if isNumDotSpace() {
+			if l.pos >= len(l.input) {
+				continue
+			}
+			if l.isNumDotSpace() {
 				otherToken.Type = OrderedListToken
 				return
 			}
 		}
-		// Update c because pos maybe updated due to black symbol.
-		c = input[pos]
+		// Update c because l.pos maybe updated due to black symbol.
+		c = l.input[l.pos]
+		otherStart = l.pos
 
 		// Now we have to return the text token before the below token.
 		switch c {
 		case '*':
-			if nextIsSameTo(c) {
-				pos += 2
+			if l.nextIsSameTo(c) {
+				l.pos += 2
 				otherToken.Type = DoubleStarToken
 				otherToken.Value = []rune("**")
 			} else {
-				pos += 1
+				l.pos += 1
 				otherToken.Type = SingleStarToken
 				otherToken.Value = []rune("*")
 			}
 			return
 		case '_':
-			if nextIsSameTo(c) {
-				pos += 2
+			if l.nextIsSameTo(c) {
+				l.pos += 2
 				otherToken.Type = DoubleUnderscoreToken
 				otherToken.Value = []rune("__")
 			} else {
-				pos += 1
+				l.pos += 1
 				otherToken.Type = SingleUnderscoreToken
 				otherToken.Value = []rune("_")
 			}
 			return
 		case '~':
-			if nextIsSameTo(c) {
-				pos += 2
+			if l.Extensions&StrikethroughExtension != 0 && l.nextIsSameTo(c) {
+				l.pos += 2
 				otherToken.Type = DoubleTildeToken
 				otherToken.Value = []rune("~~")
 				return
 			}
 		case '`':
-			otherToken.Type = SingleBacktickToken
-			otherToken.Value = []rune("`")
-			pos++
-			return
+			n := l.countSymbol(c)
+			if l.lexCodeSpan(&otherToken, n) {
+				return
+			}
+			// Backticks which are not closed are literal.
+			if len(textToken.Value) == 0 {
+				textStart = l.pos
+			}
+			textToken.Value = append(textToken.Value, l.input[l.pos:l.pos+n]...)
+			l.pos += n
+			textEnd = l.pos
+			continue
+		case '\\':
+			if l.pos+1 < len(l.input) && isASCIIPunctuation(l.input[l.pos+1]) {
+				if len(textToken.Value) == 0 {
+					textStart = l.pos
+				}
+				textToken.Value = append(textToken.Value, l.input[l.pos+1])
+				l.pos += 2
+				textEnd = l.pos
+				continue
+			}
+		case '&':
+			if entity, n := l.getEntity(); n > 0 {
+				if len(textToken.Value) == 0 {
+					textStart = l.pos
+				}
+				textToken.Value = append(textToken.Value, entity...)
+				l.pos += n
+				textEnd = l.pos
+				continue
+			}
 		case '!':
-			if nextIsSameTo('[') {
-				pos += 2
+			if l.nextIsSameTo('[') {
+				l.pos += 2
 				otherToken.Type = ImageHeadToken
+				otherToken.Value = []rune("![")
 				return
 			}
 		case '[':
-			pos++
+			l.pos++
 			otherToken.Type = LinkHeadToken
+			otherToken.Value = []rune("[")
 			return
 		case ']':
-			if nextIsSameTo('(') {
-				pos += 2
-				for i := pos; i < len(input) && input[i] != '\n'; i++ {
-					if input[i] == ')' {
+			if l.nextIsSameTo('(') {
+				l.pos += 2
+				for i := l.pos; i < len(l.input) && l.input[i] != '\n'; i++ {
+					if l.input[i] == ')' {
 						otherToken.Type = LinkBodyToken
-						otherToken.Value = input[pos:i]
-						pos = i + 1
+						otherToken.Value = l.input[l.pos:i]
+						l.pos = i + 1
 						return
 					}
 				}
-				pos -= 2
+				l.pos -= 2
 			}
 		case '\n':
 			otherToken.Type = NewlineToken
-			pos++
+			l.pos++
 			return
 		case '\t':
 			otherToken.Type = TabToken
-			pos++
+			l.pos++
 			return
 		}
-		pos++
+		l.pos++
 		if c != '\r' {
+			if len(textToken.Value) == 0 {
+				textStart = l.pos - 1
+			}
 			textToken.Value = append(textToken.Value, c)
+			textEnd = l.pos
+		}
+	}
+}
+
+// lexCodeSpan sets the token to the opening backticks of a code span at the current position,
+// and queues the literal content and the closing backticks, it returns false if the backticks are not closed.
+// A code span is closed by the same number of backticks in the same paragraph.
+func (l *Lexer) lexCodeSpan(token *Token, n int) bool {
+	start := l.pos + n
+	end := -1
+	for i := start; i < len(l.input); {
+		if l.input[i] == '\n' && l.isBlankLine(i+1) {
+			break
+		}
+		if l.input[i] != '`' {
+			i++
+			continue
+		}
+		m := 0
+		for i+m < len(l.input) && l.input[i+m] == '`' {
+			m++
+		}
+		if m == n {
+			end = i
+			break
+		}
+		i += m
+	}
+	if end < 0 {
+		return false
+	}
+	// Line endings are spaces, and a single space is stripped from both sides if there is something else.
+	var content []rune
+	for _, c := range l.input[start:end] {
+		if c == '\n' {
+			c = ' '
+		}
+		if c != '\r' {
+			content = append(content, c)
+		}
+	}
+	contentStart, contentEnd := start, end
+	if len(content) > 2 && content[0] == ' ' && content[len(content)-1] == ' ' && len(trimSpace(content)) != 0 {
+		content = content[1 : len(content)-1]
+		contentStart, contentEnd = start+1, end-1
+	}
+	token.Type = SingleBacktickToken
+	token.Value = l.input[l.pos:start]
+	token.Start, token.End = l.position(l.pos), l.position(start)
+	l.pendingTokens = append(l.pendingTokens, Token{
+		Type:  TextToken,
+		Value: content,
+		Start: l.position(contentStart),
+		End:   l.position(contentEnd),
+	}, Token{
+		Type:  SingleBacktickToken,
+		Value: l.input[end : end+n],
+		Start: l.position(end),
+		End:   l.position(end + n),
+	})
+	l.pos = end + n
+	return true
+}
+
+// isBlankLine reports whether the line starting at start has only whitespaces.
+func (l *Lexer) isBlankLine(start int) bool {
+	return len(trimSpace(l.input[start:l.lineEnd(start)])) == 0
+}
+
+// getEntity returns the character of the HTML entity reference at the current position, such as &copy; or &#123;,
+// and the length of the reference, the length is 0 if there is no valid reference.
+func (l *Lexer) getEntity() (entity []rune, n int) {
+	end := l.pos + 1
+	for end < len(l.input) && end-l.pos <= 32 && l.input[end] != ';' {
+		end++
+	}
+	if end >= len(l.input) || l.input[end] != ';' || !isEntityName(l.input[l.pos+1:end]) {
+		return nil, 0
+	}
+	reference := string(l.input[l.pos : end+1])
+	decoded := html.UnescapeString(reference)
+	if decoded == reference {
+		// It is not a known entity.
+		return nil, 0
+	}
+	if l.input[l.pos+1] != '#' && len([]rune(decoded)) > 2 {
+		// Only a prefix is an entity without the semicolon, like &copy in &copyright;, the rest is left as it is,
+		// while a whole named entity is at most two characters.
+		return nil, 0
+	}
+	return []rune(decoded), end + 1 - l.pos
+}
+
+// isEntityName reports whether the name can be the name of an entity reference,
+// which is a word, a decimal number like #123 or a hexadecimal number like #x7B.
+func isEntityName(name []rune) bool {
+	digits, maxLength := "0123456789", 7
+	switch {
+	case len(name) > 1 && name[0] == '#' && (name[1] == 'x' || name[1] == 'X'):
+		name, digits, maxLength = name[2:], "0123456789abcdefABCDEF", 6
+	case len(name) > 0 && name[0] == '#':
+		name = name[1:]
+	case len(name) > 0 && unicode.IsLetter(name[0]):
+		digits, maxLength = digits+"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ", 32
+	default:
+		return false
+	}
+	if len(name) == 0 || len(name) > maxLength {
+		return false
+	}
+	for _, c := range name {
+		if !strings.ContainsRune(digits, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// isASCIIPunctuation reports whether the character can be escaped by a backslash.
+func isASCIIPunctuation(c rune) bool {
+	return strings.ContainsRune("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c)
+}
+
+// getQuoteContent collects the lines of the quote starting at the current position,
+// and returns them without their quote markers, so that they can be tokenized as a document on their own.
+func (l *Lexer) getQuoteContent() (content []rune, offsets []int) {
+	lazy := false
+	for start := l.pos; start < len(l.input); {
+		end := l.lineEnd(start)
+		from := start
+		for from < end && isSpace(l.input[from]) {
+			from++
+		}
+		if from < end && l.input[from] == '>' {
+			from++
+			if from < end && (l.input[from] == ' ' || l.input[from] == '\t') {
+				from++
+			}
+		} else if !lazy || from == end || startsBlock(l.input[from:end]) {
+			break
+		}
+		line := l.input[from:end]
+		// A line which does not begin a new block continues the paragraph of the previous line lazily,
+		// even if that paragraph is in a nested quote.
+		text := line
+		for len(text) > 0 && (text[0] == '>' || isSpace(text[0])) {
+			text = text[1:]
+		}
+		lazy = len(text) != 0 && !startsBlock(text)
+		if start != l.pos {
+			content = append(content, '\n')
+			offsets = append(offsets, l.offsets[start-1])
+		}
+		content = append(content, line...)
+		offsets = append(offsets, l.offsets[from:end]...)
+		l.pos = end
+		start = end + 1
+	}
+	offsets = append(offsets, l.offsets[l.pos])
+	return
+}
+
+// lineEnd returns the position of the newline which ends the line containing start.
+func (l *Lexer) lineEnd(start int) (end int) {
+	for end = start; end < len(l.input) && l.input[end] != '\n'; end++ {
+	}
+	return
+}
+
+// lexTable tokenizes the current line if it is part of a table, otherwise it returns false.
+// A table row is emitted as a TableRowToken followed by one TableCellToken for each cell.
+func (l *Lexer) lexTable(token *Token) bool {
+	if l.Extensions&TableExtension == 0 {
+		return false
+	}
+	end := l.lineEnd(l.pos)
+	line := l.input[l.pos:end]
+	if l.tableAlignments == nil {
+		// A table starts with a header row which is followed by a delimiter row.
+		if end >= len(l.input) || !containsPipe(line) || startsBlock(line) {
+			return false
+		}
+		delimiterRow := l.input[end+1 : l.lineEnd(end+1)]
+		alignments, ok := parseDelimiterRow(delimiterRow)
+		cells, positions := splitTableRow(line)
+		if !ok || len(cells) != len(alignments) {
+			return false
+		}
+		l.tableAlignments = alignments
+		l.tableDelimiterNext = true
+		l.emitTableRow(token, cells, positions)
+	} else if l.tableDelimiterNext {
+		token.Type = TableDelimiterToken
+		token.Value = l.tableAlignments
+		l.tableDelimiterNext = false
+	} else if len(trimSpace(line)) == 0 || startsBlock(line) {
+		// A blank line or the beginning of another block ends the table.
+		l.tableAlignments = nil
+		return false
+	} else {
+		cells, positions := splitTableRow(line)
+		l.emitTableRow(token, cells, positions)
+	}
+	l.pos = end
+	return true
+}
+
+// emitTableRow emits the cells in the current line, positions are the positions of their runes in the line.
+func (l *Lexer) emitTableRow(token *Token, cells [][]rune, positions [][]int) {
+	token.Type = TableRowToken
+	for i, cell := range cells {
+		cellToken := Token{Type: TableCellToken, Value: cell}
+		start, end := l.pos, l.pos
+		if len(cell) != 0 {
+			start, end = l.pos+positions[i][0], l.pos+positions[i][len(cell)-1]+1
+		}
+		for _, position := range positions[i] {
+			cellToken.offsets = append(cellToken.offsets, l.offsets[l.pos+position])
+		}
+		cellToken.offsets = append(cellToken.offsets, l.offsets[end])
+		cellToken.Start, cellToken.End = l.position(start), l.position(end)
+		l.pendingTokens = append(l.pendingTokens, cellToken)
+	}
+}
+
+func isSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+func trimSpace(line []rune) []rune {
+	for len(line) > 0 && isSpace(line[0]) {
+		line = line[1:]
+	}
+	for len(line) > 0 && isSpace(line[len(line)-1]) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+func containsPipe(line []rune) bool {
+	for i, c := range line {
+		if c == '|' && (i == 0 || line[i-1] != '\\') {
+			return true
+		}
+	}
+	return false
+}
+
+// startsBlock reports whether the line begins a block which can interrupt a table.
+func startsBlock(line []rune) bool {
+	line = trimSpace(line)
+	if len(line) == 0 {
+		return false
+	}
+	switch line[0] {
+	case '#', '>':
+		return true
+	case '`', '~':
+		return len(line) >= 3 && line[1] == line[0] && line[2] == line[0]
+	case '-', '+', '*':
+		return len(line) == 1 || line[1] == ' '
+	}
+	return false
+}
+
+// splitTableRow splits a table row into trimmed cells, escaped pipes are unescaped.
+// The positions of the runes of every cell in the line are returned too.
+func splitTableRow(line []rune) (cells [][]rune, positions [][]int) {
+	start, end := 0, len(line)
+	for start < end && isSpace(line[start]) {
+		start++
+	}
+	for end > start && isSpace(line[end-1]) {
+		end--
+	}
+	if start < end && line[start] == '|' {
+		start++
+	}
+	if end > start && line[end-1] == '|' && (end-start == 1 || line[end-2] != '\\') {
+		end--
+	}
+	cell, position := []rune{}, []int{}
+	addCell := func() {
+		for len(cell) > 0 && isSpace(cell[0]) {
+			cell, position = cell[1:], position[1:]
+		}
+		for len(cell) > 0 && isSpace(cell[len(cell)-1]) {
+			cell, position = cell[:len(cell)-1], position[:len(position)-1]
+		}
+		cells = append(cells, cell)
+		positions = append(positions, position)
+		cell, position = []rune{}, []int{}
+	}
+	for i := start; i < end; i++ {
+		switch {
+		case line[i] == '\\' && i+1 < end && line[i+1] == '|':
+			cell = append(cell, '|')
+			position = append(position, i+1)
+			i++
+		case line[i] == '|':
+			addCell()
+		default:
+			cell = append(cell, line[i])
+			position = append(position, i)
+		}
+	}
+	addCell()
+	return
+}
+
+// parseDelimiterRow returns the column alignments if the line is a table delimiter row like "| :--- | :---: |".
+func parseDelimiterRow(line []rune) (alignments []rune, ok bool) {
+	if !containsPipe(line) {
+		return nil, false
+	}
+	cells, _ := splitTableRow(line)
+	for _, cell := range cells {
+		if len(cell) == 0 {
+			return nil, false
+		}
+		left := cell[0] == ':'
+		right := cell[len(cell)-1] == ':'
+		dashes := cell
+		if left {
+			dashes = dashes[1:]
+		}
+		if right && len(dashes) > 0 {
+			dashes = dashes[:len(dashes)-1]
+		}
+		if len(dashes) == 0 {
+			return nil, false
+		}
+		for _, c := range dashes {
+			if c != '-' {
+				return nil, false
+			}
+		}
+		alignment := NoAlignment
+		switch {
+		case left && right:
+			alignment = CenterAlignment
+		case left:
+			alignment = LeftAlignment
+		case right:
+			alignment = RightAlignment
 		}
+		alignments = append(alignments, alignment)
 	}
+	return alignments, true
 }
 
 func PrintToken(token Token) {
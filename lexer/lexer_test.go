@@ -86,7 +86,7 @@ Type 1 end.
 `
 
 func TestTokenizeCodeBlock(t *testing.T) {
-	checkTokenNumber(t, markdown5, 22, false)
+	checkTokenNumber(t, markdown5, 9, false)
 }
 
 const markdown6 = `
@@ -95,3 +95,192 @@ const markdown6 = `
 func TestTokenizeRealArticle(t *testing.T) {
 	checkTokenNumber(t, markdown6, 1, true)
 }
+
+const markdown7 = `
+| Name | Value |
+| :--- | ---: |
+| ` + "`a\\|b`" + ` | **bold** |
+`
+
+func TestTokenizeTable(t *testing.T) {
+	checkTokenNumber(t, markdown7, 11, false)
+}
+
+func TestSplitTableRow(t *testing.T) {
+	cells, _ := splitTableRow([]rune(`| a | b\|c |  |`))
+	expected := []string{"a", "b|c", ""}
+	if len(cells) != len(expected) {
+		t.Fatalf("There should be %d cells, got %d", len(expected), len(cells))
+	}
+	for i, cell := range cells {
+		if string(cell) != expected[i] {
+			t.Errorf("Cell %d should be %q, got %q", i, expected[i], string(cell))
+		}
+	}
+}
+
+const markdown8 = `
+> Quote
+> > Nested quote
+lazy continuation
+
+Not quote.
+`
+
+func TestTokenizeQuote(t *testing.T) {
+	checkTokenNumber(t, markdown8, 6, false)
+	l := New(markdown8)
+	_ = l.NextToken()
+	if token := l.NextToken(); token.Type != QuoteToken || string(token.Value) != "Quote\n> Nested quote\nlazy continuation" {
+		t.Errorf("Wrong quote token: <%s, %q>", TokenTypeName[token.Type], string(token.Value))
+	}
+}
+
+func TestTokenizeFencedCodeBlock(t *testing.T) {
+	tests := []struct {
+		markdown string
+		expected string
+	}{
+		{"```\na\n```", "a\n"},
+		{"~~~go\na\n~~~", "a\n"},
+		{"````md\n```go\na\n```\n````", "```go\na\n```\n"},
+		{"~~~\n```\n~~~~~", "```\n"},
+		{"```\na\n``\nb", "a\n``\nb\n"},
+		{"1. Item\n   ```\n   a\n     b\n   ```", "a\n  b\n"},
+		{"Text\n\n    a\n\n    b\n\n", "a\n\nb\n"},
+		{"\ta\n", "a\n"},
+	}
+	for _, test := range tests {
+		l := New(test.markdown)
+		token := l.NextToken()
+		for token.Type != CodeBlockToken && token.Type != EofToken {
+			token = l.NextToken()
+		}
+		if string(token.Value) != test.expected {
+			t.Errorf("The code block of %q should be %q, got %q", test.markdown, test.expected, string(token.Value))
+		}
+	}
+}
+
+func TestTokenizeIndentedText(t *testing.T) {
+	// Indented lines continuing a paragraph or a list item are not code blocks.
+	for _, markdown := range []string{"Text\n    more text", "* Item\n\n    more text"} {
+		l := New(markdown)
+		for token := l.NextToken(); token.Type != EofToken; token = l.NextToken() {
+			if token.Type == CodeBlockToken {
+				t.Errorf("There should be no code block in %q", markdown)
+			}
+		}
+	}
+}
+
+func TestTokenPosition(t *testing.T) {
+	tests := []struct {
+		markdown string
+		value    string
+		start    Position
+		end      Position
+	}{
+		{"# 标题 *a*", "a", Position{1, 11, 10}, Position{1, 12, 11}},
+		{"Line\n\n> 引用\n> > *b*", "b", Position{4, 6, 20}, Position{4, 7, 21}},
+		{"| a | b |\n| --- | --- |\n| 1 | `c` |", "c", Position{3, 8, 31}, Position{3, 9, 32}},
+	}
+	for _, test := range tests {
+		token, ok := findToken(New(test.markdown), test.value)
+		if !ok {
+			t.Errorf("There should be a token %q in %q", test.value, test.markdown)
+			continue
+		}
+		if token.Start != test.start || token.End != test.end {
+			t.Errorf("Token %q in %q should be at %v-%v, got %v-%v",
+				test.value, test.markdown, test.start, test.end, token.Start, token.End)
+		}
+	}
+}
+
+// findToken looks for the text token with the value, in the nested lexers of quotes and table cells as well.
+func findToken(l *Lexer, value string) (Token, bool) {
+	for token := l.NextToken(); token.Type != EofToken; token = l.NextToken() {
+		if token.Type == TextToken && string(token.Value) == value {
+			return token, true
+		}
+		if token.Type == QuoteToken || token.Type == TableCellToken {
+			if found, ok := findToken(l.Nested(token), value); ok {
+				return found, true
+			}
+		}
+	}
+	return Token{}, false
+}
+
+func TestTokenizeEscapeAndEntity(t *testing.T) {
+	tests := []struct {
+		markdown string
+		expected string
+	}{
+		{`\*a\* \_b\_ \[c\] \` + "`d\\`", "*a* _b_ [c] `d`"},
+		{`\a \\ \`, `\a \ \`},
+		{"&copy; &#123; &#x41; &amp;lt;", "© { A &lt;"},
+		{"&nope; AT&T &#12345678; &#;", "&nope; AT&T &#12345678; &#;"},
+		{"&copyright; &ampx; &notit; &semi; &notin;", "&copyright; &ampx; &notit; ; ∉"},
+		{"a&#59;b &#x3B;", "a;b ;"},
+		{"`a\\*b &amp;`", "a\\*b &amp;"},
+		{"`` a `b` ``", "a `b`"},
+		{"`unclosed *", "`unclosed "},
+	}
+	for _, test := range tests {
+		text := ""
+		l := New(test.markdown)
+		for token := l.NextToken(); token.Type != EofToken; token = l.NextToken() {
+			if token.Type == TextToken {
+				text += string(token.Value)
+			}
+		}
+		if text != test.expected {
+			t.Errorf("The text of %q should be %q, got %q", test.markdown, test.expected, text)
+		}
+	}
+}
+
+func TestTokenizeEndOfInput(t *testing.T) {
+	// Tokenizing should never read beyond the end of the input.
+	for _, markdown := range []string{"#", "a\n ", "1", "*", "- [", "+ [+]", "[", "]("} {
+		l := New(markdown)
+		for i := 0; l.NextToken().Type != EofToken; i++ {
+			if i > len(markdown) {
+				t.Fatalf("There are too many tokens in %q", markdown)
+			}
+		}
+	}
+}
+
+func TestTokenizeFrontMatter(t *testing.T) {
+	tests := []struct {
+		markdown string
+		format   string
+		content  string
+	}{
+		{"---\ntitle: A\n---\n# A\n", "yaml", "title: A\n"},
+		{"---\ntitle: A\n...\n", "yaml", "title: A\n"},
+		{"+++\ntitle = 'A'\n+++\n", "toml", "title = 'A'\n"},
+		{"---\n---\n", "yaml", ""},
+	}
+	for _, test := range tests {
+		token := New(test.markdown).NextToken()
+		if token.Type != FrontMatterToken || string(token.Info) != test.format || string(token.Value) != test.content {
+			t.Errorf("Wrong front matter token of %q: <%s, %q, %q>",
+				test.markdown, TokenTypeName[token.Type], string(token.Info), string(token.Value))
+		}
+	}
+	for _, markdown := range []string{"---\ntitle: A\n", "a\n---\nb\n---\n", "> ---\n> a\n> ---\n"} {
+		l := New(markdown)
+		for token := l.NextToken(); token.Type != EofToken; token = l.NextToken() {
+			if token.Type == QuoteToken {
+				token = l.Nested(token).NextToken()
+			}
+			if token.Type == FrontMatterToken {
+				t.Errorf("There should be no front matter in %q", markdown)
+			}
+		}
+	}
+}
@@ -1,50 +1,237 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"md2html/converter"
+	"md2html/parser"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-func ConvertFile(path string) {
+// The exit codes of md2html.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// config is the configuration given by the command line flags.
+type config struct {
+	options converter.Options
+	// output is where the HTML of a single markdown file is saved, "-" means the standard output.
+	output string
+	// outputDir is where the HTML files are saved, mirroring the tree of the markdown files.
+	outputDir string
+	// incremental skips the markdown files whose HTML files are up to date.
+	incremental bool
+	quiet       bool
+	// astJSON saves the AST of the markdown in JSON instead of the HTML.
+	astJSON bool
+	// fromASTJSON reads an AST in JSON from the standard input instead of markdown.
+	fromASTJSON bool
+	// inputs are the files every HTML file depends on besides its markdown file, such as the CSS file.
+	inputs []string
+}
+
+// source is a markdown file found under root, root is the path given on the command line.
+type source struct {
+	root string
+	path string
+}
+
+// variables are the -var flags, which are .Vars in the page template.
+type variables map[string]interface{}
+
+func (v variables) String() string {
+	return fmt.Sprint(map[string]interface{}(v))
+}
+
+func (v variables) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 {
+		return fmt.Errorf("%q should be name=value", value)
+	}
+	v[value[:i]] = value[i+1:]
+	return nil
+}
+
+// list is a flag which can be repeated, every value is appended to the list.
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *list) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// errUpToDate is returned by ConvertFile if the HTML file is newer than the markdown file.
+var errUpToDate = errors.New("the HTML file is up to date")
+
+func (c *config) logf(format string, v ...interface{}) {
+	if !c.quiet {
+		log.Printf(format, v...)
+	}
+}
+
+// outputPath returns where the HTML converted from the markdown file is saved, or its AST with -ast-json.
+func (c *config) outputPath(file source) string {
+	path := file.path
+	if c.outputDir != "" {
+		rel, err := filepath.Rel(file.root, file.path)
+		if err != nil || file.root == file.path {
+			rel = filepath.Base(file.path)
+		}
+		path = filepath.Join(c.outputDir, rel)
+	}
+	extension := ".html"
+	if c.astJSON {
+		extension = ".json"
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + extension
+}
+
+// upToDate reports whether the output is newer than the markdown file and the other inputs.
+func (c *config) upToDate(path, output string) bool {
+	if !c.incremental || output == "-" {
+		return false
+	}
+	fi, err := os.Stat(output)
+	if err != nil {
+		return false
+	}
+	for _, input := range append([]string{path}, c.inputs...) {
+		if inputInfo, err := os.Stat(input); err != nil || inputInfo.ModTime().After(fi.ModTime()) {
+			return false
+		}
+	}
+	return true
+}
+
+// ConvertFile converts the markdown file and saves the HTML at output, "-" means the standard output.
+// It returns errUpToDate without converting the file if incremental is set and the HTML file is up to date.
+func (c *config) ConvertFile(path, output string) error {
+	if c.upToDate(path, output) {
+		return errUpToDate
+	}
 	markdown, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	log.Printf("Converting file %q.", path)
-	html := converter.Convert(string(markdown), true)
-	convertedFilename := strings.TrimSuffix(path, filepath.Ext(path))
-	convertedFilename += ".html"
-	convertedFile, err := os.Create(convertedFilename)
+	c.logf("Converting file %q.", path)
+	options := c.options
+	options.DefaultTitle = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	html, err := c.render(string(markdown), options)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	_, err = convertedFile.WriteString(html)
+	if output != "-" {
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return err
+		}
+	}
+	if err := writeOutput(output, html); err != nil {
+		return err
+	}
+	if output != "-" {
+		c.logf("Converted file saved at %q.", output)
+	}
+	return nil
+}
+
+// render returns the HTML of the markdown, or its AST in JSON with -ast-json.
+func (c *config) render(markdown string, options converter.Options) (string, error) {
+	if !c.astJSON {
+		return converter.ConvertWithOptions(markdown, options), nil
+	}
+	p := parser.New(markdown)
+	p.Extensions = options.Extensions
+	data, err := json.MarshalIndent(p.Parse(), "", "  ")
+	return string(data) + "\n", err
+}
+
+// convert converts the markdown file to the HTML file at its output path, and reports the result.
+func (c *config) convert(file source) error {
+	output := c.output
+	if output == "" {
+		output = c.outputPath(file)
+	}
+	err := c.ConvertFile(file.path, output)
+	switch err {
+	case nil:
+	case errUpToDate:
+		c.logf("Skipped file %q, its HTML file is up to date.", file.path)
+	default:
+		log.Printf("Error: failed to convert file %q: %v", file.path, err)
+	}
+	return err
+}
+
+// ConvertStream converts the markdown read from r and saves the HTML at output, "-" means the standard output.
+func (c *config) ConvertStream(r io.Reader, output string) error {
+	markdown, err := ioutil.ReadAll(r)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	_ = convertedFile.Close()
-	log.Printf("Converted file saved at %q.", convertedFilename)
+	if c.fromASTJSON {
+		var ast parser.Node
+		if err := json.Unmarshal(markdown, &ast); err != nil {
+			return fmt.Errorf("invalid AST: %v", err)
+		}
+		return writeOutput(output, converter.ConvertAST(&ast, c.options).HTML)
+	}
+	html, err := c.render(string(markdown), c.options)
+	if err != nil {
+		return err
+	}
+	return writeOutput(output, html)
 }
 
-func main() {
-	var files []string
-	if len(os.Args) == 1 {
-		os.Args = append(os.Args, "./")
+func writeOutput(output, html string) error {
+	if output == "-" {
+		_, err := io.WriteString(os.Stdout, html)
+		return err
+	}
+	convertedFile, err := os.Create(output)
+	if err != nil {
+		return err
 	}
-	for _, path := range os.Args[1:] {
-		fi, err := os.Stat(path)
+	_, err = convertedFile.WriteString(html)
+	if closeErr := convertedFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// isTerminal reports whether the file is a terminal rather than a pipe or a regular file.
+func isTerminal(file *os.File) bool {
+	fi, err := file.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// findMarkdownFiles returns the markdown files under the paths, and the errors of the paths which can not be read.
+func findMarkdownFiles(paths []string) (files []source, errs []error) {
+	for _, root := range paths {
+		fi, err := os.Stat(root)
 		if err != nil {
-			log.Fatal(err)
+			errs = append(errs, err)
+			continue
 		}
 		switch mode := fi.Mode(); {
 		case mode.IsDir():
-			_ = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 				if err != nil {
-					return err
+					errs = append(errs, err)
+					return nil
 				}
 				if info.IsDir() {
 					return nil
@@ -52,15 +239,171 @@ func main() {
 				if matched, err := filepath.Match("*.md", filepath.Base(path)); err != nil {
 					return err
 				} else if matched {
-					files = append(files, path)
+					files = append(files, source{root: root, path: path})
 				}
 				return nil
 			})
+			if err != nil {
+				errs = append(errs, err)
+			}
 		case mode.IsRegular():
-			files = append(files, path)
+			files = append(files, source{root: root, path: root})
+		}
+	}
+	return
+}
+
+// parseArguments parses the command line flags, which can be mixed with the paths, and returns the paths.
+func parseArguments() (paths []string) {
+	flag.Parse()
+	for args := flag.Args(); len(args) != 0; args = flag.Args() {
+		paths = append(paths, args[0])
+		_ = flag.CommandLine.Parse(args[1:])
+	}
+	return
+}
+
+func usageError(message string) {
+	log.Println("Error: " + message)
+	os.Exit(exitUsage)
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+	c := &config{options: converter.DefaultOptions()}
+	c.options.Vars = map[string]interface{}{}
+	flag.StringVar(&c.output, "o", "", "save the HTML of a single markdown file at this path, \"-\" means the standard output")
+	flag.StringVar(&c.outputDir, "output-dir", "", "save the HTML files in this directory, mirroring the tree of the markdown files")
+	fragment := flag.Bool("fragment", false, "only output the article instead of a full HTML page")
+	css := flag.String("css", "", "use the style sheet in this file instead of the theme")
+	flag.StringVar(&c.options.Theme, "theme", "", "the built-in style sheet, which is one of "+
+		strings.Join(converter.ThemeNames(), ", ")+", auto follows the dark mode of the system")
+	flag.Var((*list)(&c.options.Stylesheets), "css-link", "link the style sheet at this `URL` instead of inlining the theme, it can be repeated")
+	printCSS := flag.Bool("print-css", false, "print the style sheet of the theme, which can be saved for -css-link")
+	template := flag.String("template", "", "use the page template in this file, which is written in the syntax of html/template, see converter.TemplateData")
+	flag.StringVar(&c.options.Title, "title", "", "the title of the pages, the title in the front matter or the name of the markdown file is used by default")
+	flag.BoolVar(&c.quiet, "quiet", false, "only report errors")
+	flag.BoolVar(&c.incremental, "incremental", false, "skip the markdown files whose HTML files are newer than them and the -css and -template files")
+	force := flag.Bool("force", false, "convert every markdown file even with -incremental")
+	flag.BoolVar(&c.options.HeadingAnchors, "anchors", false, "add a ¶ link to every heading")
+	flag.BoolVar(&c.options.TOC, "toc", false, "add a table of contents at the [TOC] marker or at the top of the page")
+	flag.Var(variables(c.options.Vars), "var", "set a variable of the page template like `name=value`, it can be repeated")
+	flag.BoolVar(&c.astJSON, "ast-json", false, "save the AST of the markdown in JSON instead of the HTML, see parser.Node.MarshalJSON")
+	flag.BoolVar(&c.fromASTJSON, "from-ast-json", false, "read an AST in JSON from the standard input instead of markdown")
+	watch := flag.Bool("watch", false, "keep converting the markdown files when they are added or changed")
+	flag.Usage = func() {
+		log.SetFlags(0)
+		log.Println("Usage: md2html [flags] [file or directory ...]")
+		log.Println("       md2html serve [-addr address] [directory]")
+		log.Println("Markdown is read from the standard input if the path is \"-\", or there is no path and the standard input is not a terminal.")
+		flag.PrintDefaults()
+	}
+	paths := parseArguments()
+	c.options.FullPage = !*fragment
+	c.incremental = c.incremental && !*force
+	if c.options.Theme != "" {
+		if _, ok := converter.LookupTheme(c.options.Theme); !ok {
+			usageError(fmt.Sprintf("there is no theme %q.", c.options.Theme))
+		}
+	}
+	if *printCSS {
+		css, _ := converter.LookupTheme(c.options.Theme)
+		if c.options.Theme == "" {
+			css, _ = converter.LookupTheme(converter.DefaultTheme)
+		}
+		fmt.Print(css)
+		return
+	}
+	for _, file := range []struct {
+		path  string
+		value *string
+	}{{*css, &c.options.CSS}, {*template, &c.options.Template}} {
+		if file.path == "" {
+			continue
+		}
+		content, err := ioutil.ReadFile(file.path)
+		if err != nil {
+			usageError(err.Error())
+		}
+		*file.value = string(content)
+		c.inputs = append(c.inputs, file.path)
+	}
+	if c.options.Template != "" {
+		// The template is parsed once for all the files, and its errors are reported before converting any file.
+		if page, err := converter.ParseTemplate(c.options.Template); err != nil {
+			usageError(err.Error())
+		} else if !strings.Contains(c.options.Template, "{{") {
+			log.Println("Warning: the template is in the old format of fmt, it can not use the front matter and -var.")
+		} else {
+			c.options.PageTemplate = page
 		}
 	}
+	if c.output != "" && c.outputDir != "" {
+		usageError("-o and -output-dir can not be used together.")
+	}
+
+	if len(paths) == 0 && !isTerminal(os.Stdin) {
+		paths = append(paths, "-")
+	}
+	if len(paths) == 0 {
+		paths = append(paths, "./")
+	}
+	if c.astJSON && c.fromASTJSON {
+		usageError("-ast-json and -from-ast-json can not be used together.")
+	}
+	if c.fromASTJSON && (len(paths) != 1 || paths[0] != "-") {
+		usageError("-from-ast-json only reads the standard input.")
+	}
+	if len(paths) == 1 && paths[0] == "-" {
+		if *watch {
+			usageError("-watch can not be used with the standard input.")
+		}
+		if c.output == "" {
+			c.output = "-"
+		}
+		if err := c.ConvertStream(os.Stdin, c.output); err != nil {
+			log.Println("Error:", err)
+			os.Exit(exitFailure)
+		}
+		return
+	}
+
+	files, errs := findMarkdownFiles(paths)
+	if c.output != "" && len(files) != 1 {
+		usageError("-o can only be used with a single markdown file.")
+	}
+	if c.output != "" && *watch {
+		usageError("-o can not be used with -watch.")
+	}
+	for _, err := range errs {
+		log.Println("Error:", err)
+	}
+	failed := len(errs)
+	converted, skipped := 0, 0
 	for _, file := range files {
-		ConvertFile(file)
+		switch err := c.convert(file); err {
+		case nil:
+			converted++
+		case errUpToDate:
+			skipped++
+		default:
+			failed++
+		}
+	}
+	if *watch {
+		c.watch(paths, pollInterval)
+	}
+	summary := fmt.Sprintf("%d converted, %d failed.", converted, failed)
+	if c.incremental {
+		summary = fmt.Sprintf("%d converted, %d up to date, %d failed.", converted, skipped, failed)
+	}
+	if failed != 0 {
+		log.Println("Error: " + summary)
+		os.Exit(exitFailure)
 	}
+	c.logf("%s", summary)
+	os.Exit(exitOK)
 }
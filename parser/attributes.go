@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+)
+
+// parseAttributes parses attributes like `{#id .class key=value key="quoted value" flag}`, the braces are optional.
+// Classes are joined by spaces, and a flag without a value is "true".
+func parseAttributes(text string) (attributes map[string]string) {
+	text = strings.TrimSpace(text)
+	text = strings.TrimPrefix(text, "{")
+	text = strings.TrimSuffix(text, "}")
+	runes := []rune(text)
+	attributes = make(map[string]string)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) || runes[i] == ',' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && runes[i] != '=' && !unicode.IsSpace(runes[i]) && runes[i] != ',' {
+			i++
+		}
+		key := string(runes[start:i])
+		value := "true"
+		if i < len(runes) && runes[i] == '=' {
+			i++
+			if i < len(runes) && (runes[i] == '"' || runes[i] == '\'') {
+				quote := runes[i]
+				i++
+				start = i
+				for i < len(runes) && runes[i] != quote {
+					i++
+				}
+				value = string(runes[start:i])
+				i++
+			} else {
+				start = i
+				for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != ',' {
+					i++
+				}
+				value = string(runes[start:i])
+			}
+		}
+		switch {
+		case strings.HasPrefix(key, "#") && len(key) > 1:
+			attributes["id"] = key[1:]
+		case strings.HasPrefix(key, ".") && len(key) > 1:
+			if attributes["class"] != "" {
+				attributes["class"] += " "
+			}
+			attributes["class"] += key[1:]
+		case key != "":
+			attributes[key] = value
+		}
+	}
+	return
+}
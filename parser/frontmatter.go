@@ -0,0 +1,533 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The front matter is parsed by small parsers of YAML and TOML, which only support what metadata usually needs:
+// strings, numbers, booleans, lists and nested maps. Dates are kept as strings.
+
+// parseFrontMatter parses the front matter in the given format, which is "yaml" or "toml".
+func parseFrontMatter(format, text string) (metadata map[string]interface{}, err error) {
+	switch format {
+	case "yaml":
+		return parseYAML(text)
+	case "toml":
+		return parseTOML(text)
+	}
+	return nil, fmt.Errorf("unknown front matter format %q", format)
+}
+
+// yamlLine is a line of YAML without its indentation.
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+func parseYAML(text string) (map[string]interface{}, error) {
+	var lines []yamlLine
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		content := strings.TrimLeft(line, " ")
+		lines = append(lines, yamlLine{number: i + 1, indent: len(line) - len(content), text: content})
+	}
+	y := &yamlParser{lines: lines}
+	y.skipBlankLines()
+	if y.pos == len(y.lines) {
+		return map[string]interface{}{}, nil
+	}
+	value, err := y.parseBlock(y.lines[y.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	if y.skipBlankLines(); y.pos != len(y.lines) {
+		return nil, y.errorf("unexpected indentation")
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("yaml: the front matter should be a map")
+	}
+	return metadata, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (y *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("yaml: line %d: %s", y.lines[y.pos].number, fmt.Sprintf(format, args...))
+}
+
+// skipBlankLines skips the blank lines and the comment lines.
+func (y *yamlParser) skipBlankLines() {
+	for y.pos < len(y.lines) && (y.lines[y.pos].text == "" || strings.HasPrefix(y.lines[y.pos].text, "#")) {
+		y.pos++
+	}
+}
+
+// parseBlock parses the list or the map whose lines are indented by indent.
+func (y *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isYAMLListItem(y.lines[y.pos].text) {
+		return y.parseList(indent)
+	}
+	return y.parseMap(indent)
+}
+
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (y *yamlParser) parseList(indent int) (list []interface{}, err error) {
+	list = []interface{}{}
+	for y.skipBlankLines(); y.pos < len(y.lines) && y.lines[y.pos].indent == indent; y.skipBlankLines() {
+		line := y.lines[y.pos]
+		if !isYAMLListItem(line.text) {
+			break
+		}
+		item := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		var value interface{}
+		switch _, _, isMap := splitYAMLKey(item); {
+		case item == "":
+			value, err = y.parseNested(indent, false)
+		case isMap:
+			// The item is a map starting on the same line, e.g. "- name: value".
+			y.lines[y.pos].indent += len(line.text) - len(item)
+			y.lines[y.pos].text = item
+			value, err = y.parseMap(y.lines[y.pos].indent)
+		default:
+			value, err = parseYAMLScalar(item)
+			y.pos++
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return
+}
+
+func (y *yamlParser) parseMap(indent int) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	for y.skipBlankLines(); y.pos < len(y.lines) && y.lines[y.pos].indent == indent; y.skipBlankLines() {
+		line := y.lines[y.pos]
+		if isYAMLListItem(line.text) {
+			break
+		}
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, y.errorf("%q is not a key and a value", line.text)
+		}
+		var value interface{}
+		var err error
+		switch rest {
+		case "":
+			value, err = y.parseNested(indent, true)
+		case "|", ">", "|-", ">-":
+			value = y.parseBlockScalar(indent, rest)
+		default:
+			value, err = parseYAMLScalar(rest)
+			y.pos++
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// parseNested parses the value of a key or a list item which starts on the next line,
+// a list can be as indented as the key of its map.
+func (y *yamlParser) parseNested(indent int, inMap bool) (interface{}, error) {
+	y.pos++
+	y.skipBlankLines()
+	if y.pos == len(y.lines) {
+		return nil, nil
+	}
+	next := y.lines[y.pos]
+	if next.indent > indent || inMap && next.indent == indent && isYAMLListItem(next.text) {
+		return y.parseBlock(next.indent)
+	}
+	return nil, nil
+}
+
+// parseBlockScalar parses a literal (|) or folded (>) multi-line string.
+func (y *yamlParser) parseBlockScalar(indent int, style string) string {
+	y.pos++
+	var lines []string
+	blockIndent := -1
+	for ; y.pos < len(y.lines); y.pos++ {
+		line := y.lines[y.pos]
+		if line.text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if line.indent <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = line.indent
+		}
+		lines = append(lines, strings.Repeat(" ", line.indent-blockIndent)+line.text)
+	}
+	for len(lines) != 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	separator := "\n"
+	if style[0] == '>' {
+		separator = " "
+	}
+	text := strings.Join(lines, separator)
+	if !strings.HasSuffix(style, "-") {
+		text += "\n"
+	}
+	return text
+}
+
+// splitYAMLKey splits "key: value" into the key and the value, the key may be quoted.
+func splitYAMLKey(text string) (key, value string, ok bool) {
+	i := 0
+	if text != "" && (text[0] == '"' || text[0] == '\'') {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		i = end + 2
+	}
+	for ; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			key = strings.TrimSpace(text[:i])
+			if unquoted, err := parseYAMLScalar(key); err == nil {
+				key = fmt.Sprint(unquoted)
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+		if text[i] == ' ' && i+1 < len(text) && text[i+1] == '#' {
+			break
+		}
+	}
+	return "", "", false
+}
+
+// parseYAMLScalar parses a value written on a single line, which may be a flow list like [a, b].
+func parseYAMLScalar(text string) (interface{}, error) {
+	text = stripComment(text)
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("yaml: %q is not closed", text)
+		}
+		list := []interface{}{}
+		for _, item := range splitFlow(text[1 : len(text)-1]) {
+			value, err := parseYAMLScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case strings.HasPrefix(text, `"`):
+		return strconv.Unquote(text)
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("yaml: %s is not closed", text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	}
+	switch text {
+	case "", "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	return parseNumber(text), nil
+}
+
+// parseNumber returns the integer or the float written in the text, or the text itself if it is not a number.
+func parseNumber(text string) interface{} {
+	if n, err := strconv.ParseInt(text, 10, 0); err == nil {
+		return int(n)
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil && strings.ContainsAny(text, "0123456789") {
+		return f
+	}
+	return text
+}
+
+// stripComment removes the comment at the end of a value, a comment starts with " #" outside quotes.
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return strings.TrimSpace(text[:i])
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+// splitFlow splits the items of a flow list or an inline table by the commas outside quotes and brackets.
+func splitFlow(text string) (items []string) {
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(text[start:]); last != "" {
+		items = append(items, last)
+	}
+	return
+}
+
+func parseTOML(text string) (map[string]interface{}, error) {
+	metadata := map[string]interface{}{}
+	table := metadata
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		number := i + 1
+		line := stripComment(strings.TrimSpace(lines[i]))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			var err error
+			if table, err = tomlTable(metadata, line); err != nil {
+				return nil, fmt.Errorf("toml: line %d: %v", number, err)
+			}
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("toml: line %d: %q is not a key and a value", number, line)
+		}
+		keys := tomlKeys(line[:eq])
+		value := strings.TrimSpace(line[eq+1:])
+		// Arrays and multi-line strings may span lines.
+		for i+1 < len(lines) && !tomlValueComplete(value) {
+			i++
+			value += "\n" + stripComment(strings.TrimSpace(lines[i]))
+		}
+		parsed, err := parseTOMLValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("toml: line %d: %v", number, err)
+		}
+		parent, err := tomlSubTable(table, keys[:len(keys)-1])
+		if err != nil {
+			return nil, fmt.Errorf("toml: line %d: %v", number, err)
+		}
+		parent[keys[len(keys)-1]] = parsed
+	}
+	return metadata, nil
+}
+
+// tomlKeys splits a dotted key like `a."b.c".d` into its parts.
+func tomlKeys(text string) (keys []string) {
+	var quote byte
+	key := ""
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				key += string(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '.':
+			keys = append(keys, strings.TrimSpace(key))
+			key = ""
+		default:
+			key += string(c)
+		}
+	}
+	return append(keys, strings.TrimSpace(key))
+}
+
+// tomlTable returns the table of a header like [a.b], or the new table appended to the array of [[a.b]].
+func tomlTable(metadata map[string]interface{}, header string) (map[string]interface{}, error) {
+	if strings.HasPrefix(header, "[[") && strings.HasSuffix(header, "]]") {
+		keys := tomlKeys(header[2 : len(header)-2])
+		parent, err := tomlSubTable(metadata, keys[:len(keys)-1])
+		if err != nil {
+			return nil, err
+		}
+		name := keys[len(keys)-1]
+		array, _ := parent[name].([]interface{})
+		table := map[string]interface{}{}
+		parent[name] = append(array, table)
+		return table, nil
+	}
+	if !strings.HasSuffix(header, "]") {
+		return nil, fmt.Errorf("%q is not closed", header)
+	}
+	return tomlSubTable(metadata, tomlKeys(header[1:len(header)-1]))
+}
+
+// tomlSubTable returns the table at the path of keys under the table, the missing tables are created.
+func tomlSubTable(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		switch next := table[key].(type) {
+		case nil:
+			sub := map[string]interface{}{}
+			table[key] = sub
+			table = sub
+		case map[string]interface{}:
+			table = next
+		case []interface{}:
+			// The last table of an array of tables.
+			if len(next) == 0 {
+				return nil, fmt.Errorf("%q is not a table", key)
+			}
+			last, ok := next[len(next)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%q is not a table", key)
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("%q is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+// tomlValueComplete reports whether the brackets and the multi-line strings of the value are closed.
+func tomlValueComplete(value string) bool {
+	for _, quote := range []string{`"""`, "'''"} {
+		if strings.HasPrefix(value, quote) {
+			return len(value) >= 6 && strings.HasSuffix(value, quote)
+		}
+	}
+	depth := 0
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// escapeMultilineString escapes the newlines and the quotes which need no escape in a multi-line basic string,
+// so that it can be unquoted like a basic string.
+func escapeMultilineString(content string) (escaped string) {
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; c {
+		case '\\':
+			if i+1 < len(content) {
+				escaped += content[i : i+2]
+				i++
+			} else {
+				escaped += content[i:]
+			}
+		case '\n':
+			escaped += `\n`
+		case '"':
+			escaped += `\"`
+		default:
+			escaped += content[i : i+1]
+		}
+	}
+	return
+}
+
+func parseTOMLValue(text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, `"""`), strings.HasPrefix(text, "'''"):
+		quote := text[:3]
+		if len(text) < 6 || !strings.HasSuffix(text, quote) {
+			return nil, fmt.Errorf("%s is not closed", quote)
+		}
+		content := strings.TrimPrefix(text[3:len(text)-3], "\n")
+		if quote == "'''" {
+			return content, nil
+		}
+		return strconv.Unquote(`"` + escapeMultilineString(content) + `"`)
+	case strings.HasPrefix(text, `"`):
+		return strconv.Unquote(text)
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("%s is not closed", text)
+		}
+		return text[1 : len(text)-1], nil
+	case strings.HasPrefix(text, "["):
+		list := []interface{}{}
+		for _, item := range splitFlow(strings.TrimSuffix(text[1:], "]")) {
+			value, err := parseTOMLValue(strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case strings.HasPrefix(text, "{"):
+		table := map[string]interface{}{}
+		for _, item := range splitFlow(strings.TrimSuffix(text[1:], "}")) {
+			eq := strings.IndexByte(item, '=')
+			if eq < 0 {
+				return nil, fmt.Errorf("%q is not a key and a value", item)
+			}
+			value, err := parseTOMLValue(strings.TrimSpace(item[eq+1:]))
+			if err != nil {
+				return nil, err
+			}
+			keys := tomlKeys(item[:eq])
+			parent, err := tomlSubTable(table, keys[:len(keys)-1])
+			if err != nil {
+				return nil, err
+			}
+			parent[keys[len(keys)-1]] = value
+		}
+		return table, nil
+	case text == "true":
+		return true, nil
+	case text == "false":
+		return false, nil
+	}
+	if value := parseNumber(strings.Replace(text, "_", "", -1)); value != strings.Replace(text, "_", "", -1) {
+		return value, nil
+	}
+	// Dates and times are kept as strings.
+	return text, nil
+}
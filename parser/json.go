@@ -0,0 +1,195 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"md2html/lexer"
+)
+
+// The JSON of a node is an object with these fields, the fields which do not apply to the node are omitted:
+//
+//	type        the name of the node type in NodeTypeName, such as "TitleNode"
+//	text        the text of a TextNode
+//	kind        the kind of a ListNode: "unordered", "ordered", "uncompleted_task" or "completed_task" for an item,
+//	            and "placeholder" for the list holding the items
+//	level       the level of a TitleNode from 1 to 6, or the nesting level of a list item starting from 1
+//	url         the destination of a LinkNode or an ImageNode
+//	code        the code of a CodeBlockNode
+//	info        the info string of a CodeBlockNode
+//	attributes  the attributes of a TitleNode or a CodeBlockNode, such as {"id": "intro"}
+//	header      true for the header row of a table
+//	align       the alignment of a TableCellNode: "left", "center" or "right"
+//	metadata    the front matter of an ArticleNode, its numbers are float64 after unmarshalling
+//	start, end  the positions {"line", "column", "offset"} in the markdown, omitted for a node without position
+//	children    the child nodes
+type jsonNode struct {
+	Type       string                 `json:"type"`
+	Text       string                 `json:"text,omitempty"`
+	Kind       string                 `json:"kind,omitempty"`
+	Level      int                    `json:"level,omitempty"`
+	URL        string                 `json:"url,omitempty"`
+	Code       string                 `json:"code,omitempty"`
+	Info       string                 `json:"info,omitempty"`
+	Attributes map[string]string      `json:"attributes,omitempty"`
+	Header     bool                   `json:"header,omitempty"`
+	Align      string                 `json:"align,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Start      *lexer.Position        `json:"start,omitempty"`
+	End        *lexer.Position        `json:"end,omitempty"`
+	Children   []*Node                `json:"children,omitempty"`
+}
+
+// listKindName are the kinds of a ListNode in JSON by the first rune of its value.
+var listKindName = map[rune]string{
+	-1: "placeholder",
+	0:  "unordered",
+	1:  "ordered",
+	2:  "uncompleted_task",
+	3:  "completed_task",
+}
+
+var alignmentName = map[rune]string{
+	lexer.LeftAlignment:   "left",
+	lexer.CenterAlignment: "center",
+	lexer.RightAlignment:  "right",
+}
+
+// MarshalJSON encodes the node and its children in the format described at jsonNode.
+func (node Node) MarshalJSON() ([]byte, error) {
+	if int(node.Type) >= len(NodeTypeName) || node.Type < 0 {
+		return nil, fmt.Errorf("unknown node type %d", node.Type)
+	}
+	j := jsonNode{
+		Type:       NodeTypeName[node.Type],
+		Info:       node.Info,
+		Attributes: node.Attributes,
+		Metadata:   node.Metadata,
+		Children:   node.Children,
+	}
+	if node.Start.Line != 0 {
+		j.Start, j.End = &node.Start, &node.End
+	}
+	switch node.Type {
+	case TextNode:
+		j.Text = string(node.Value)
+	case TitleNode:
+		j.Level = int(node.Value[0])
+	case ListNode:
+		j.Kind = listKindName[node.Value[0]]
+		j.Level = int(node.Value[1])
+	case LinkNode:
+		fallthrough
+	case ImageNode:
+		j.URL = string(node.Value)
+	case CodeBlockNode:
+		j.Code = string(node.Value)
+	case TableRowNode:
+		j.Header = node.Value[0] == 1
+	case TableCellNode:
+		j.Align = alignmentName[node.Value[0]]
+	}
+	return json.Marshal(j)
+}
+
+// isListItem reports whether the node is a ListNode which is an item rather than the placeholder holding the items.
+func isListItem(node *Node) bool {
+	return node.Type == ListNode && node.Value[0] != -1
+}
+
+// checkChildren checks that the children of the node are the ones it can have, so that the AST can be rendered:
+// the rows of a table, the cells of a row, and the items of a list.
+func checkChildren(node *Node) error {
+	for i, child := range node.Children {
+		switch {
+		case child == nil:
+			return fmt.Errorf("a child of a %s is null", NodeTypeName[node.Type])
+		case node.Type == TableNode && child.Type != TableRowNode:
+			return fmt.Errorf("a TableNode should only have TableRowNode children, got a %s", NodeTypeName[child.Type])
+		case node.Type == TableRowNode && child.Type != TableCellNode:
+			return fmt.Errorf("a TableRowNode should only have TableCellNode children, got a %s", NodeTypeName[child.Type])
+		case node.Type == ListNode && !isListItem(node) && !isListItem(child):
+			return fmt.Errorf("a list should only have list items, got a %s", NodeTypeName[child.Type])
+		case node.Type == ListNode && isListItem(node) && i != 0 && child.Type == ListNode && !isListItem(child):
+			return fmt.Errorf("a list item should only have list items as its sub-lists")
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes a node and its children in the format described at jsonNode.
+func (node *Node) UnmarshalJSON(data []byte) error {
+	var j jsonNode
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	nodeType := NoneNode
+	for i, name := range NodeTypeName {
+		if name == j.Type {
+			nodeType = NodeType(i)
+		}
+	}
+	if nodeType == NoneNode {
+		return fmt.Errorf("unknown node type %q", j.Type)
+	}
+	*node = Node{
+		Type:       nodeType,
+		Info:       j.Info,
+		Attributes: j.Attributes,
+		Metadata:   j.Metadata,
+		Children:   j.Children,
+	}
+	if j.Start != nil && j.End != nil {
+		node.Start, node.End = *j.Start, *j.End
+	}
+	switch nodeType {
+	case TitleNode, ParagraphNode, TableCellNode, ItalicNode, BoldNode, InlineCodeNode, StrikethroughNode, LinkNode,
+		ImageNode:
+		// Their first child is their content.
+		if len(node.Children) == 0 {
+			return fmt.Errorf("a %s should have a child", j.Type)
+		}
+	case ListNode:
+		if j.Kind != "placeholder" && len(node.Children) == 0 {
+			return fmt.Errorf("a list item should have a child")
+		}
+	}
+	switch nodeType {
+	case TextNode:
+		node.Value = []rune(j.Text)
+	case TitleNode:
+		if j.Level < 1 || j.Level > 6 {
+			return fmt.Errorf("the level of a heading should be from 1 to 6, got %d", j.Level)
+		}
+		node.Value = []rune{rune(j.Level)}
+	case ListNode:
+		kind, ok := rune(0), false
+		for value, name := range listKindName {
+			if name == j.Kind {
+				kind, ok = value, true
+			}
+		}
+		if !ok {
+			return fmt.Errorf("unknown list kind %q", j.Kind)
+		}
+		node.Value = []rune{kind, rune(j.Level)}
+	case LinkNode:
+		fallthrough
+	case ImageNode:
+		node.Value = []rune(j.URL)
+	case CodeBlockNode:
+		node.Value = []rune(j.Code)
+	case TableRowNode:
+		node.Value = []rune{0}
+		if j.Header {
+			node.Value[0] = 1
+		}
+	case TableCellNode:
+		node.Value = []rune{lexer.NoAlignment}
+		for value, name := range alignmentName {
+			if name == j.Align {
+				node.Value[0] = value
+			}
+		}
+	}
+	return checkChildren(node)
+}
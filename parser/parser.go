@@ -3,8 +3,11 @@ package parser
 import (
 	"fmt"
 	"github.com/disiqueira/gotree"
+	"io"
 	"log"
 	"md2html/lexer"
+	"os"
+	"strings"
 )
 
 type NodeType int8
@@ -25,6 +28,11 @@ const (
 	StrikethroughNode
 	LinkNode
 	ImageNode
+	TableNode
+	TableRowNode
+	TableCellNode
+	ParagraphNode
+	LineBreakNode
 )
 
 var NodeTypeName = []string{
@@ -43,12 +51,46 @@ var NodeTypeName = []string{
 	"StrikethroughNode",
 	"LinkNode",
 	"ImageNode",
+	"TableNode",
+	"TableRowNode",
+	"TableCellNode",
+	"ParagraphNode",
+	"LineBreakNode",
 }
 
 type Node struct {
 	Type     NodeType
 	Value    []rune
 	Children []*Node
+	// Info is the info string of a code block, such as "go {linenos=true}".
+	Info string
+	// Attributes are the attributes written in the info string of a code block, such as {linenos=true title="main.go"}.
+	Attributes map[string]string
+	// Metadata is the front matter of an ArticleNode, nil if the document has none.
+	Metadata map[string]interface{}
+	// Start is where the node starts in the document, and End is right after where it ends.
+	Start lexer.Position
+	End   lexer.Position
+}
+
+// extend makes the node end at end, if it ends before that.
+func (node *Node) extend(end lexer.Position) {
+	if end.Offset > node.End.Offset {
+		node.End = end
+	}
+}
+
+// Language returns the language of a code block, which is the first word of its info string before any attributes,
+// such as "go" of "go{linenos=true}".
+func (node *Node) Language() string {
+	fields := strings.Fields(node.Info)
+	if len(fields) == 0 {
+		return ""
+	}
+	if i := strings.IndexByte(fields[0], '{'); i >= 0 {
+		return fields[0][:i]
+	}
+	return fields[0]
 }
 
 func (node Node) String() (str string) {
@@ -58,6 +100,10 @@ func (node Node) String() (str string) {
 		str += fmt.Sprintf(": %q", string(node.Value))
 	case TitleNode:
 		str += fmt.Sprintf(": %d", node.Value[0])
+	case CodeBlockNode:
+		if node.Info != "" {
+			str += fmt.Sprintf(": %q", node.Info)
+		}
 	case ImageNode:
 		fallthrough
 	case LinkNode:
@@ -77,52 +123,97 @@ func (node Node) String() (str string) {
 			str += "Placeholder List"
 		}
 		str += fmt.Sprintf(" (level %d)", int(node.Value[1]))
+	case TableRowNode:
+		if node.Value[0] == 1 {
+			str += ": Header"
+		}
+	case TableCellNode:
+		switch node.Value[0] {
+		case lexer.LeftAlignment:
+			str += ": Left"
+		case lexer.CenterAlignment:
+			str += ": Center"
+		case lexer.RightAlignment:
+			str += ": Right"
+		}
 	}
 	return
 }
 
-// If you add any global variables, don't forget to progress them in func Parse(markdown string)!
-var tokenBuffer []lexer.Token
-var pos = 0
-var tabCounter = 0
+// Parser builds the AST of a markdown document.
+// Every document should get its own Parser, so that different documents can be parsed concurrently.
+type Parser struct {
+	// Extensions are the enabled syntax extensions, lexer.DefaultExtensions by default.
+	Extensions lexer.Extension
+
+	lexer       *lexer.Lexer
+	tokenBuffer []lexer.Token
+	pos         int
+	tabCounter  int
+}
+
+// New returns a Parser reading from the given markdown.
+func New(markdown string) *Parser {
+	return &Parser{
+		Extensions:  lexer.DefaultExtensions,
+		lexer:       lexer.New(markdown),
+		tokenBuffer: nil,
+		pos:         0,
+		tabCounter:  0,
+	}
+}
+
+// subParser returns a parser for a part of the document, such as the content of a quote.
+func (p *Parser) subParser(l *lexer.Lexer) *Parser {
+	l.Extensions = p.Extensions
+	return &Parser{
+		Extensions: p.Extensions,
+		lexer:      l,
+	}
+}
 
-func getToken() (token lexer.Token) {
-	if pos == len(tokenBuffer) {
+func (p *Parser) getToken() (token lexer.Token) {
+	if p.pos == len(p.tokenBuffer) {
 		// Noting in the buffer or all tokens are used.
-		pos++
-		token = lexer.NextToken()
-		tokenBuffer = append(tokenBuffer, token)
+		p.pos++
+		token = p.lexer.NextToken()
+		p.tokenBuffer = append(p.tokenBuffer, token)
 	} else {
-		token = tokenBuffer[pos]
-		pos++
+		token = p.tokenBuffer[p.pos]
+		p.pos++
 	}
-	if pos > 5 {
+	if p.pos > 5 {
 		// Remove the most outdated token
-		tokenBuffer = tokenBuffer[1:]
-		pos--
+		p.tokenBuffer = p.tokenBuffer[1:]
+		p.pos--
 	}
 	return
 }
 
-func restoreToken() {
-	if pos == 0 {
+func (p *Parser) restoreToken() {
+	if p.pos == 0 {
 		log.Println("Warning: nothing to restore!")
 	} else {
-		pos--
+		p.pos--
 	}
 }
 
-func nextTokenIs(tokenType lexer.TokenType) (yes bool) {
-	token := getToken()
+func (p *Parser) nextTokenIs(tokenType lexer.TokenType) (yes bool) {
+	token := p.getToken()
 	yes = token.Type == tokenType
-	restoreToken()
+	p.restoreToken()
 	return
 }
 
 func PrintAST(root *Node) {
+	FprintAST(os.Stdout, root)
+}
+
+// FprintAST prints the AST as a tree to w.
+func FprintAST(w io.Writer, root *Node) {
 	tree := gotree.New("Root")
 	printASTHelper(root, &tree)
-	fmt.Println(tree.Print())
+	_, _ = fmt.Fprintln(w, tree.Print())
 }
 
 func printASTHelper(astNode *Node, treeNode *gotree.Tree) {
@@ -134,12 +225,15 @@ func printASTHelper(astNode *Node, treeNode *gotree.Tree) {
 	}
 }
 
+// Parse parses the markdown with a new Parser.
 func Parse(markdown string) (root *Node) {
-	tokenBuffer = nil
-	pos = 0
-	tabCounter = 0
-	lexer.Tokenize(markdown)
-	root = parseArticle()
+	return New(markdown).Parse()
+}
+
+// Parse builds the AST, it should be called only once for each Parser.
+func (p *Parser) Parse() (root *Node) {
+	p.lexer.Extensions = p.Extensions
+	root = p.parseArticle()
 	preprocessAST(root)
 	return
 }
@@ -177,6 +271,23 @@ func preprocessAST(root *Node) {
 	root.Children = newChildren
 	// Then we have to combine those list items.
 	combineListNode(root)
+	for _, child := range root.Children {
+		extendListNode(child)
+	}
+}
+
+// extendListNode makes every list node cover its nested list items.
+func extendListNode(root *Node) {
+	if root.Type != ListNode || len(root.Children) == 0 {
+		return
+	}
+	for _, child := range root.Children {
+		extendListNode(child)
+	}
+	if root.Start.Line == 0 {
+		root.Start = root.Children[0].Start
+	}
+	root.extend(root.Children[len(root.Children)-1].End)
 }
 
 func combineListNode(root *Node) {
@@ -227,26 +338,37 @@ func processListNode(nodes []*Node) {
 	}
 }
 
-func parseArticle() (root *Node) {
-	root = parseSectionList()
+func (p *Parser) parseArticle() (root *Node) {
+	var metadata map[string]interface{}
+	if p.nextTokenIs(lexer.FrontMatterToken) {
+		token := p.getToken()
+		var err error
+		if metadata, err = parseFrontMatter(string(token.Info), string(token.Value)); err != nil {
+			// It is not front matter, such as a dividing line followed by text, so it is parsed as markdown.
+			p.lexer = p.lexer.WithoutFrontMatter()
+			p.tokenBuffer, p.pos = nil, 0
+		}
+	}
+	root = p.parseSectionList()
 	root.Type = ArticleNode
+	root.Metadata = metadata
 	return
 }
 
-func parseSectionList() (root *Node) {
+func (p *Parser) parseSectionList() (root *Node) {
 	node := Node{}
 	root = &node
 	for {
-		token := getToken()
-		restoreToken()
+		token := p.getToken()
+		p.restoreToken()
 		current := &Node{}
 		switch token.Type {
 		case lexer.TitleToken:
-			current = parseTitle()
+			current = p.parseTitle()
 		case lexer.DividingLineToken:
-			current = parseDividingLine()
+			current = p.parseDividingLine()
 		case lexer.CodeBlockToken:
-			current = parseCodeBlock()
+			current = p.parseCodeBlock()
 		case lexer.UncompletedTaskToken:
 			fallthrough
 		case lexer.CompletedTaskToken:
@@ -254,28 +376,32 @@ func parseSectionList() (root *Node) {
 		case lexer.UnorderedListToken:
 			fallthrough
 		case lexer.OrderedListToken:
-			current = parseList()
+			current = p.parseList()
 		case lexer.QuoteToken:
-			current = parseQuote()
+			current = p.parseQuote()
+		case lexer.TableRowToken:
+			current = p.parseTable()
 		case lexer.NewlineToken:
-			_ = getToken()
-			tabCounter = 0
+			_ = p.getToken()
+			p.tabCounter = 0
 			continue
 		case lexer.TabToken:
-			tabCounter++
-			_ = getToken()
+			p.tabCounter++
+			_ = p.getToken()
 			continue
 		case lexer.EofToken:
+			root.Start = lexer.Position{Line: 1, Column: 1}
+			root.End = token.Start
 			return
 		default:
-			current = parseContent(false)
+			current = p.parseParagraph()
 		}
 		root.Children = append(root.Children, current)
 	}
 }
 
-func parseTitle() (root *Node) {
-	token := getToken()
+func (p *Parser) parseTitle() (root *Node) {
+	token := p.getToken()
 	if token.Type != lexer.TitleToken {
 		log.Println("Error: not a title token!")
 	}
@@ -283,29 +409,95 @@ func parseTitle() (root *Node) {
 	root = &node
 	root.Type = TitleNode
 	root.Value = token.Value
-	root.Children = append(root.Children, parseContent(true))
+	root.Start, root.End = token.Start, token.End
+	root.Children = append(root.Children, p.parseContent(true))
+	root.extend(root.Children[0].End)
+	parseHeadingAttributes(root)
 	return
 }
 
-func parseDividingLine() (root *Node) {
-	token := getToken()
+// parseHeadingAttributes moves the attributes at the end of a heading like `# Title {#id .class}` into its attributes,
+// the attributes should start with an id or a class.
+func parseHeadingAttributes(root *Node) {
+	content := root.Children[0]
+	// The attributes may be split into many nodes, e.g. the underscores of `{#a_b_c}` are lexed as an italic b,
+	// so the nodes from the last text with a brace are taken as they are written.
+	first := len(content.Children)
+	for first > 0 {
+		child := content.Children[first-1]
+		if child.Type != TextNode && child.Type != ItalicNode && child.Type != BoldNode {
+			break
+		}
+		first--
+		if child.Type == TextNode && strings.ContainsRune(string(child.Value), '{') {
+			break
+		}
+	}
+	text := ""
+	for _, child := range content.Children[first:] {
+		text += markdownText(child)
+	}
+	text = strings.TrimRight(text, " \t")
+	start := strings.LastIndex(text, "{")
+	if start < 0 || !strings.HasSuffix(text, "}") ||
+		!strings.HasPrefix(text[start:], "{#") && !strings.HasPrefix(text[start:], "{.") {
+		return
+	}
+	root.Attributes = parseAttributes(text[start:])
+	rest := &Node{Type: TextNode, Value: []rune(strings.TrimRight(text[:start], " \t"))}
+	if first < len(content.Children) {
+		rest.Start, rest.End = content.Children[first].Start, content.Children[len(content.Children)-1].End
+	}
+	content.Children = append(content.Children[:first], rest)
+}
+
+// markdownText returns the text of an inline node as it is written, italic and bold are written with underscores.
+func markdownText(node *Node) string {
+	switch node.Type {
+	case ItalicNode:
+		return "_" + markdownText(node.Children[0]) + "_"
+	case BoldNode:
+		return "__" + markdownText(node.Children[0]) + "__"
+	}
+	text := string(node.Value)
+	for _, child := range node.Children {
+		text += markdownText(child)
+	}
+	return text
+}
+
+func (p *Parser) parseDividingLine() (root *Node) {
+	token := p.getToken()
 	if token.Type != lexer.DividingLineToken {
 		log.Println("Error: not a dividing line token!")
 	}
 	node := Node{}
 	root = &node
 	root.Type = DividingLineNode
+	root.Start, root.End = token.Start, token.End
+	return
+}
+
+func (p *Parser) parseParagraph() (root *Node) {
+	node := Node{}
+	root = &node
+	root.Type = ParagraphNode
+	content := p.parseContent(false)
+	root.Children = append(root.Children, content)
+	root.Start, root.End = content.Start, content.End
+	// The spaces at the end of a paragraph are not a line break.
+	if n := len(content.Children); n > 0 && content.Children[n-1].Type == TextNode {
+		last := content.Children[n-1]
+		last.Value = []rune(strings.TrimRight(string(last.Value), " "))
+	}
 	return
 }
 
-func parseContent(singleLine bool) (root *Node) {
+func (p *Parser) parseContent(singleLine bool) (root *Node) {
 	// First we should retrieve all the tokens this content node need.
 	var tokens []lexer.Token
-	for token := getToken(); token.Type != lexer.EofToken; token = getToken() {
-		if singleLine && token.Type == lexer.NewlineToken {
-			break
-		}
-		if token.Type == lexer.NewlineToken && (!nextTokenIs(lexer.TextToken)) {
+	for token := p.getToken(); token.Type != lexer.EofToken; token = p.getToken() {
+		if token.Type == lexer.NewlineToken && (singleLine || !p.continuesContent()) {
 			break
 		}
 		tokens = append(tokens, token)
@@ -313,6 +505,43 @@ func parseContent(singleLine bool) (root *Node) {
 	return constructContentNode(0, len(tokens)-1, &tokens)
 }
 
+// continuesContent reports whether the line after a newline token continues the content,
+// the indentation of the line is skipped if it does.
+func (p *Parser) continuesContent() (yes bool) {
+	consumed := 0
+	token := p.getToken()
+	// The buffer can only restore 4 tokens after the newline token.
+	for consumed < 3 && token.Type == lexer.TabToken {
+		consumed++
+		token = p.getToken()
+	}
+	p.restoreToken()
+	switch token.Type {
+	case lexer.TextToken:
+		fallthrough
+	case lexer.SingleStarToken:
+		fallthrough
+	case lexer.DoubleStarToken:
+		fallthrough
+	case lexer.SingleUnderscoreToken:
+		fallthrough
+	case lexer.DoubleUnderscoreToken:
+		fallthrough
+	case lexer.SingleBacktickToken:
+		fallthrough
+	case lexer.DoubleTildeToken:
+		fallthrough
+	case lexer.LinkHeadToken:
+		fallthrough
+	case lexer.ImageHeadToken:
+		return true
+	}
+	for ; consumed > 0; consumed-- {
+		p.restoreToken()
+	}
+	return false
+}
+
 func constructContentNode(start, end int, tokens *[]lexer.Token) (root *Node) {
 	node := Node{}
 	root = &node
@@ -321,6 +550,9 @@ func constructContentNode(start, end int, tokens *[]lexer.Token) (root *Node) {
 		log.Println("Warning: content node is blank!")
 		return
 	}
+	if start <= end {
+		root.Start, root.End = (*tokens)[start].Start, (*tokens)[end].End
+	}
 	// return -1 if not found, notice it doesn't not check the start point
 	findThisTypeToken := func(t lexer.TokenType, start int) (pos int) {
 		for pos = start + 1; pos < len(*tokens); pos++ {
@@ -363,6 +595,9 @@ func constructContentNode(start, end int, tokens *[]lexer.Token) (root *Node) {
 			current = &node
 			node.Type = TextNode
 			node.Value = (*tokens)[i].Value
+			node.Start, node.End = (*tokens)[i].Start, (*tokens)[i].End
+		case lexer.NewlineToken:
+			current = constructLineBreakNode(root, (*tokens)[i])
 		case lexer.LinkHeadToken:
 			fallthrough
 		case lexer.ImageHeadToken:
@@ -371,10 +606,13 @@ func constructContentNode(start, end int, tokens *[]lexer.Token) (root *Node) {
 				(*tokens)[i+2].Type == lexer.LinkBodyToken {
 				current.Type = getNodeTypeBySymToken((*tokens)[i])
 				current.Value = (*tokens)[i+2].Value
+				current.Start, current.End = (*tokens)[i].Start, (*tokens)[i+2].End
 				current.Children = append(current.Children, &Node{
 					Type:     TextNode,
 					Value:    (*tokens)[i+1].Value,
 					Children: nil,
+					Start:    (*tokens)[i+1].Start,
+					End:      (*tokens)[i+1].End,
 				})
 				i += 2
 			} else {
@@ -407,6 +645,12 @@ func constructContentNode(start, end int, tokens *[]lexer.Token) (root *Node) {
 				// Don't forget to update i
 				i = pos
 			}
+		case lexer.LinkBodyToken:
+			// Not paired, fallback to the text it is written as.
+			(*tokens)[i].Type = lexer.TextToken
+			(*tokens)[i].Value = []rune("](" + string((*tokens)[i].Value) + ")")
+			i--
+			continue
 		default:
 			// Fallback to text token.
 			(*tokens)[i].Type = lexer.TextToken
@@ -418,32 +662,60 @@ func constructContentNode(start, end int, tokens *[]lexer.Token) (root *Node) {
 	return
 }
 
+// constructLineBreakNode turns a newline token into a hard line break if the line ends with two spaces or a backslash,
+// otherwise it is a soft line break, which is a text node of a newline.
+func constructLineBreakNode(root *Node, token lexer.Token) (current *Node) {
+	current = &Node{Type: TextNode, Value: []rune("\n"), Start: token.Start, End: token.End}
+	if len(root.Children) == 0 || root.Children[len(root.Children)-1].Type != TextNode {
+		return
+	}
+	last := root.Children[len(root.Children)-1]
+	value := last.Value
+	if len(value) > 0 && value[len(value)-1] == '\\' {
+		current.Type = LineBreakNode
+		current.Value = nil
+		last.Value = value[:len(value)-1]
+		return
+	}
+	spaces := 0
+	for len(value) > 0 && value[len(value)-1] == ' ' {
+		value = value[:len(value)-1]
+		spaces++
+	}
+	if spaces >= 2 {
+		current.Type = LineBreakNode
+		current.Value = nil
+	}
+	last.Value = value
+	return
+}
+
 func constructRichTextNode(start, end int, tokens *[]lexer.Token, nodeType NodeType) (root *Node) {
 	node := Node{}
 	root = &node
 	root.Type = nodeType
+	root.Start, root.End = (*tokens)[start].Start, (*tokens)[end].End
 	subNode := constructContentNode(start+1, end-1, tokens)
 	root.Children = append(root.Children, subNode)
 	return
 }
 
-func parseQuote() (root *Node) {
-	token := getToken()
+func (p *Parser) parseQuote() (root *Node) {
+	token := p.getToken()
 	if token.Type != lexer.QuoteToken {
 		log.Println("Error: not a quote token!")
 	}
 	node := Node{}
 	root = &node
 	root.Type = QuoteNode
-	root.Children = append(root.Children, parseContent(true))
-	for nextTokenIs(lexer.QuoteToken) {
-		root.Children = append(root.Children, parseContent(true))
-	}
+	root.Start, root.End = token.Start, token.End
+	// The content of a quote is a document on its own.
+	root.Children = p.subParser(p.lexer.Nested(token)).Parse().Children
 	return
 }
 
-func parseList() (root *Node) {
-	token := getToken()
+func (p *Parser) parseList() (root *Node) {
+	token := p.getToken()
 	if token.Type != lexer.UnorderedListToken && token.Type != lexer.OrderedListToken &&
 		token.Type != lexer.UncompletedTaskToken && token.Type != lexer.CompletedTaskToken {
 		log.Println("Error: not a list token!")
@@ -464,16 +736,44 @@ func parseList() (root *Node) {
 	default:
 		log.Println("Warning: unexpected token detected when processing list.")
 	}
-	listLevel := tabCounter + 1
-	tabCounter = 0
+	listLevel := p.tabCounter + 1
+	p.tabCounter = 0
 	root.Value = append(root.Value, rune(listType), rune(listLevel))
+	root.Start, root.End = token.Start, token.End
 	// The first child of a list node is its content.
-	root.Children = append(root.Children, parseContent(false))
+	root.Children = append(root.Children, p.parseContent(false))
+	root.extend(root.Children[0].End)
+	if codeBlock := p.parseListCodeBlock(); codeBlock != nil {
+		root.Children = append(root.Children, codeBlock)
+		root.extend(codeBlock.End)
+	}
 	return
 }
 
-func parseCodeBlock() (root *Node) {
-	token := getToken()
+// parseListCodeBlock parses the code block indented under a list item, it returns nil if there is none.
+func (p *Parser) parseListCodeBlock() (root *Node) {
+	consumed := 0
+	indented := false
+	for consumed < 4 {
+		token := p.getToken()
+		consumed++
+		if token.Type == lexer.TabToken {
+			indented = true
+		} else if token.Type == lexer.CodeBlockToken && indented {
+			p.restoreToken()
+			return p.parseCodeBlock()
+		} else if token.Type != lexer.NewlineToken || indented {
+			break
+		}
+	}
+	for ; consumed > 0; consumed-- {
+		p.restoreToken()
+	}
+	return nil
+}
+
+func (p *Parser) parseCodeBlock() (root *Node) {
+	token := p.getToken()
 	if token.Type != lexer.CodeBlockToken {
 		log.Println("Error: not a code block token!")
 	}
@@ -481,5 +781,79 @@ func parseCodeBlock() (root *Node) {
 	root = &node
 	root.Type = CodeBlockNode
 	root.Value = token.Value
+	root.Start, root.End = token.Start, token.End
+	root.Info = string(token.Info)
+	info := root.Info
+	if language := root.Language(); language != "" {
+		info = strings.TrimPrefix(info, language)
+	}
+	root.Attributes = parseAttributes(info)
+	return
+}
+
+func (p *Parser) parseTable() (root *Node) {
+	node := Node{}
+	root = &node
+	root.Type = TableNode
+	header := p.parseTableRow()
+	header.Value = []rune{1}
+	root.Children = append(root.Children, header)
+	root.Start, root.End = header.Start, header.End
+	if token := p.getToken(); token.Type != lexer.NewlineToken {
+		log.Println("Error: table header is not followed by a newline token!")
+		p.restoreToken()
+	}
+	token := p.getToken()
+	if token.Type != lexer.TableDelimiterToken {
+		log.Println("Error: not a table delimiter token!")
+		p.restoreToken()
+	}
+	alignments := token.Value
+	for p.nextTokenIs(lexer.NewlineToken) {
+		_ = p.getToken()
+		if !p.nextTokenIs(lexer.TableRowToken) {
+			p.restoreToken()
+			break
+		}
+		root.Children = append(root.Children, p.parseTableRow())
+		root.extend(root.Children[len(root.Children)-1].End)
+	}
+	// Every row should have exactly the same number of cells as the header row.
+	for _, row := range root.Children {
+		for len(row.Children) < len(alignments) {
+			row.Children = append(row.Children, &Node{
+				Type:     TableCellNode,
+				Children: []*Node{{Type: ContentNode}},
+			})
+		}
+		row.Children = row.Children[:len(alignments)]
+		for i, cell := range row.Children {
+			cell.Value = []rune{alignments[i]}
+		}
+	}
+	return
+}
+
+func (p *Parser) parseTableRow() (root *Node) {
+	token := p.getToken()
+	if token.Type != lexer.TableRowToken {
+		log.Println("Error: not a table row token!")
+	}
+	node := Node{}
+	root = &node
+	root.Type = TableRowNode
+	root.Value = []rune{0}
+	root.Start, root.End = token.Start, token.End
+	for p.nextTokenIs(lexer.TableCellToken) {
+		token = p.getToken()
+		// The content of a cell is parsed with its own parser.
+		cellParser := p.subParser(p.lexer.Nested(token))
+		root.Children = append(root.Children, &Node{
+			Type:     TableCellNode,
+			Children: []*Node{cellParser.parseContent(true)},
+			Start:    token.Start,
+			End:      token.End,
+		})
+	}
 	return
 }
@@ -1,23 +1,350 @@
 package parser
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"md2html/lexer"
+	"strings"
+	"sync"
 	"testing"
 )
 
 func TestGetAndRestoreToken(t *testing.T) {
-	markdown, err := ioutil.ReadFile("./test/test.md")
+	markdown, err := ioutil.ReadFile("../test/test.md")
 	if err != nil {
 		log.Fatal(err)
 	}
-	lexer.Tokenize(string(markdown))
+	p := New(string(markdown))
 	for {
-		token := getToken()
+		token := p.getToken()
 		lexer.PrintToken(token)
 		if token.Type == lexer.EofToken {
 			break
 		}
 	}
 }
+
+func dumpAST(node *Node, depth int) (str string) {
+	str = strings.Repeat("\t", depth) + node.String() + "\n"
+	for _, child := range node.Children {
+		str += dumpAST(child, depth+1)
+	}
+	return
+}
+
+func TestParseConcurrently(t *testing.T) {
+	var documents []string
+	for _, path := range []string{"../test/test.md", "../test/basic.md"} {
+		markdown, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		documents = append(documents, string(markdown))
+	}
+	var expected []string
+	for _, document := range documents {
+		expected = append(expected, dumpAST(Parse(document), 0))
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n := i % len(documents)
+			if got := dumpAST(Parse(documents[n]), 0); got != expected[n] {
+				t.Errorf("Concurrent parsing of document %d got a different AST:\n%s", n, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestParseTable(t *testing.T) {
+	root := Parse("| a | b |\n| :-: | --- |\n| 1 |\n| 2 | 3 | 4 |\n\nnot a row")
+	if len(root.Children) != 2 || root.Children[0].Type != TableNode {
+		t.Fatalf("There should be a table followed by a paragraph:\n%s", dumpAST(root, 0))
+	}
+	table := root.Children[0]
+	if len(table.Children) != 3 || table.Children[0].Value[0] != 1 {
+		t.Fatalf("There should be a header row and two body rows:\n%s", dumpAST(table, 0))
+	}
+	for _, row := range table.Children {
+		if len(row.Children) != 2 {
+			t.Errorf("Every row should have 2 cells:\n%s", dumpAST(row, 0))
+		}
+		if row.Children[0].Value[0] != lexer.CenterAlignment || row.Children[1].Value[0] != lexer.NoAlignment {
+			t.Errorf("Cells have wrong alignments:\n%s", dumpAST(row, 0))
+		}
+	}
+}
+
+func TestParseQuote(t *testing.T) {
+	root := Parse("> Quote\n>\n> > Nested\n>\n> * Item\n>\n> ```\n> code\n> ```")
+	if len(root.Children) != 1 || root.Children[0].Type != QuoteNode {
+		t.Fatalf("There should be only a quote:\n%s", dumpAST(root, 0))
+	}
+	quote := root.Children[0]
+	expected := []NodeType{ParagraphNode, QuoteNode, ListNode, CodeBlockNode}
+	if len(quote.Children) != len(expected) {
+		t.Fatalf("The quote should have %d children:\n%s", len(expected), dumpAST(quote, 0))
+	}
+	for i, child := range quote.Children {
+		if child.Type != expected[i] {
+			t.Errorf("Child %d of the quote should be %s, got %s", i, NodeTypeName[expected[i]], NodeTypeName[child.Type])
+		}
+	}
+}
+
+func TestParseListCodeBlock(t *testing.T) {
+	root := Parse("* Item 1\n\n  ```\n  code\n  ```\n* Item 2\n")
+	if len(root.Children) != 1 || root.Children[0].Type != ListNode {
+		t.Fatalf("There should be only a list:\n%s", dumpAST(root, 0))
+	}
+	items := root.Children[0].Children
+	if len(items) != 2 || len(items[0].Children) != 2 || items[0].Children[1].Type != CodeBlockNode {
+		t.Fatalf("The code block should be nested in the first item:\n%s", dumpAST(root, 0))
+	}
+	if code := string(items[0].Children[1].Value); code != "code\n" {
+		t.Errorf("The code should be %q, got %q", "code\n", code)
+	}
+}
+
+func TestParseCodeBlockInfo(t *testing.T) {
+	root := Parse("```go {linenos=true title=\"main file.go\" .numbered #main}\npackage main\n```")
+	if len(root.Children) != 1 || root.Children[0].Type != CodeBlockNode {
+		t.Fatalf("There should be only a code block:\n%s", dumpAST(root, 0))
+	}
+	codeBlock := root.Children[0]
+	if language := codeBlock.Language(); language != "go" {
+		t.Errorf("The language should be %q, got %q", "go", language)
+	}
+	expected := map[string]string{"linenos": "true", "title": "main file.go", "class": "numbered", "id": "main"}
+	if len(codeBlock.Attributes) != len(expected) {
+		t.Errorf("The attributes should be %v, got %v", expected, codeBlock.Attributes)
+	}
+	for key, value := range expected {
+		if codeBlock.Attributes[key] != value {
+			t.Errorf("Attribute %q should be %q, got %q", key, value, codeBlock.Attributes[key])
+		}
+	}
+	// The attributes may follow the language without a space.
+	codeBlock = Parse("```go{linenos=true}\npackage main\n```").Children[0]
+	if language := codeBlock.Language(); language != "go" || codeBlock.Attributes["linenos"] != "true" {
+		t.Errorf("The language should be %q with linenos, got %q and %v", "go", language, codeBlock.Attributes)
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	root := Parse("# Title\n\n> Quote\n> * Item\n\n| a |\n| - |\n| **b** |\n")
+	tests := []struct {
+		node       *Node
+		start, end lexer.Position
+	}{
+		{root.Children[0], position(1, 1, 0), position(1, 8, 7)},
+		{root.Children[1], position(3, 1, 9), position(4, 9, 25)},
+		{root.Children[1].Children[1], position(4, 3, 19), position(4, 9, 25)},
+		{root.Children[2], position(6, 1, 27), position(8, 10, 48)},
+		{root.Children[2].Children[1].Children[0].Children[0].Children[0], position(8, 3, 41), position(8, 8, 46)},
+	}
+	for i, test := range tests {
+		if test.node.Start != test.start || test.node.End != test.end {
+			t.Errorf("Node %d should be at %v-%v, got %v-%v:\n%s",
+				i, test.start, test.end, test.node.Start, test.node.End, dumpAST(test.node, 0))
+		}
+	}
+}
+
+func position(line, column, offset int) lexer.Position {
+	return lexer.Position{Line: line, Column: column, Offset: offset}
+}
+
+func TestParseLineBreak(t *testing.T) {
+	root := Parse("Soft\nbreak  \nhard\\\nbreak\n    indented\n\nNext paragraph")
+	if len(root.Children) != 2 || root.Children[0].Type != ParagraphNode || root.Children[1].Type != ParagraphNode {
+		t.Fatalf("There should be two paragraphs:\n%s", dumpAST(root, 0))
+	}
+	expected := []string{"Soft", "\n", "break", "", "hard", "", "break", "\n", "indented"}
+	content := root.Children[0].Children[0]
+	if len(content.Children) != len(expected) {
+		t.Fatalf("The paragraph should have %d children:\n%s", len(expected), dumpAST(content, 0))
+	}
+	for i, child := range content.Children {
+		if expected[i] == "" && child.Type != LineBreakNode {
+			t.Errorf("Child %d should be a line break, got %s", i, NodeTypeName[child.Type])
+		} else if expected[i] != "" && string(child.Value) != expected[i] {
+			t.Errorf("Child %d should be %q, got %q", i, expected[i], string(child.Value))
+		}
+	}
+}
+
+func TestParseHeadingAttributes(t *testing.T) {
+	root := Parse("# Title *x* {#my_id .big}\n# Set {a, b}\n")
+	title := root.Children[0]
+	if title.Attributes["id"] != "my_id" || title.Attributes["class"] != "big" {
+		t.Errorf("The attributes should be parsed, got %v", title.Attributes)
+	}
+	content := title.Children[0].Children
+	if last := content[len(content)-1]; last.Type != TextNode || string(last.Value) != "" {
+		t.Errorf("The attributes should be removed from the text:\n%s", dumpAST(title, 0))
+	}
+	if other := root.Children[1]; len(other.Attributes) != 0 {
+		t.Errorf("Braces which do not start with an id or a class are text, got %v", other.Attributes)
+	}
+	// The underscores of the attributes are not emphasis, and the emphasis before them is kept.
+	title = Parse("# _Title_ {#a_b_c .d__e__f}\n").Children[0]
+	if title.Attributes["id"] != "a_b_c" || title.Attributes["class"] != "d__e__f" {
+		t.Errorf("The attributes with underscores should be parsed, got %v", title.Attributes)
+	}
+	if content = title.Children[0].Children; content[0].Type != ItalicNode {
+		t.Errorf("The italic title should be kept:\n%s", dumpAST(title, 0))
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	yaml := `---
+title: "Hello: World"
+draft: false
+tags: [go, markdown]
+author:
+  name: Ann # a comment
+  posts: 3
+steps:
+- one
+- name: two
+  done: true
+summary: |
+  First line.
+  Second line.
+---
+# Hello
+`
+	toml := `+++
+title = "Hello: World"
+draft = false
+tags = [
+  "go",
+  "markdown",
+]
+date = 2024-01-02
+[author]
+name = 'Ann' # a comment
+posts = 3
+[[steps]]
+name = "one"
++++
+# Hello
+`
+	expected := map[string]string{
+		yaml: `map[author:map[name:Ann posts:3] draft:false steps:[one map[done:true name:two]] ` +
+			`summary:First line.` + "\n" + `Second line.` + "\n" + ` tags:[go markdown] title:Hello: World]`,
+		toml: `map[author:map[name:Ann posts:3] date:2024-01-02 draft:false steps:[map[name:one]] ` +
+			`tags:[go markdown] title:Hello: World]`,
+	}
+	for markdown, metadata := range expected {
+		root := Parse(markdown)
+		if actual := fmt.Sprint(root.Metadata); actual != metadata {
+			t.Errorf("The metadata should be %q, got %q", metadata, actual)
+		}
+		if len(root.Children) != 1 || root.Children[0].Type != TitleNode {
+			t.Errorf("The front matter should not be rendered:\n%s", dumpAST(root, 0))
+		}
+	}
+	// The quotes in a multi-line basic string need no escape.
+	root := Parse("+++\ndesc = \"\"\"He said \"hi\".\nNaïve \\\"ok\\\".\"\"\"\n+++\n")
+	if desc := root.Metadata["desc"]; desc != "He said \"hi\".\nNaïve \"ok\"." {
+		t.Errorf("The multi-line string should be %q, got %q", "He said \"hi\".\nNaïve \"ok\".", desc)
+	}
+	// Invalid front matter is parsed as markdown, such as a dividing line followed by text.
+	root = Parse("---\nhi\n---\nbody\n")
+	if ast := dumpAST(root, 0); root.Metadata != nil || !strings.Contains(ast, `"hi"`) || !strings.Contains(ast, `"body"`) {
+		t.Errorf("Invalid front matter should be parsed as markdown:\n%s", ast)
+	}
+	for _, markdown := range []string{
+		"+++\na = \"\"\"\n+++\n", "+++\na = '''\n+++\n", "+++\na = \"\"\"x\"\"\n+++\n", "+++\na = []\n[a.b]\n+++\n",
+	} {
+		if root := Parse(markdown); root.Metadata != nil {
+			t.Errorf("The front matter of %q should be invalid, got %v", markdown, root.Metadata)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	root := Parse("# A *b*\n\n> c\n\nd `e`\n")
+	var visited []string
+	status := Walk(root, func(node *Node, entering bool) WalkStatus {
+		name := strings.TrimSuffix(NodeTypeName[node.Type], "Node")
+		if !entering {
+			name = "/" + name
+		}
+		visited = append(visited, name)
+		if entering && node.Type == QuoteNode {
+			return WalkSkipChildren
+		}
+		return WalkContinue
+	})
+	expected := "Article Title Content Text /Text Italic Content Text /Text /Content /Italic /Content /Title Quote /Quote " +
+		"Paragraph Content Text /Text InlineCode Content Text /Text /Content /InlineCode /Content /Paragraph /Article"
+	if status != WalkContinue || strings.Join(visited, " ") != expected {
+		t.Errorf("The nodes should be visited as\n%s\ngot\n%s", expected, strings.Join(visited, " "))
+	}
+
+	visited = nil
+	status = Walk(root, func(node *Node, entering bool) WalkStatus {
+		visited = append(visited, NodeTypeName[node.Type])
+		if node.Type == ItalicNode {
+			return WalkStop
+		}
+		return WalkContinue
+	})
+	if status != WalkStop || len(visited) != 6 {
+		t.Errorf("Walking should stop at the italic node, got %v", visited)
+	}
+}
+
+func TestASTJSON(t *testing.T) {
+	markdown, err := ioutil.ReadFile("../test/test.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(Parse("---\ntitle: A\n---\n" + string(markdown)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root Node
+	if err := json.Unmarshal(data, &root); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := json.Marshal(&root); err != nil || string(again) != string(data) {
+		t.Errorf("The AST should be the same after a round trip, got %v\n%s\n%s", err, data, again)
+	}
+	if root.Metadata["title"] != "A" {
+		t.Errorf("The metadata should be kept, got %v", root.Metadata)
+	}
+
+	data, _ = json.Marshal(Parse("## A\n\n1. b\n\n| c |\n| -: |\n"))
+	for _, expected := range []string{
+		`{"type":"TitleNode","level":2,`, `{"type":"ListNode","kind":"placeholder",`,
+		`{"type":"ListNode","kind":"ordered","level":1,`, `{"type":"TableRowNode","header":true,`,
+		`{"type":"TableCellNode","align":"right",`, `{"type":"TextNode","text":"c",`,
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("The JSON should contain %s, got %s", expected, data)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"type":"Paragraph"}`, `{"type":"TitleNode","level":7,"children":[{"type":"ContentNode"}]}`,
+		`{"type":"ListNode","kind":"bullet","children":[{"type":"ContentNode"}]}`, `{"type":"LinkNode","url":"a"}`,
+		`{"type":"ListNode","kind":"placeholder","children":[{"type":"TextNode"}]}`,
+		`{"type":"ListNode","kind":"placeholder","children":[{"type":"ListNode","kind":"placeholder"}]}`,
+		`{"type":"TableNode","children":[{"type":"DividingLineNode"}]}`,
+		`{"type":"TableRowNode","children":[{"type":"TextNode"}]}`,
+		`{"type":"ArticleNode","children":[null]}`,
+	} {
+		if err := json.Unmarshal([]byte(invalid), &root); err == nil {
+			t.Errorf("%s should not be decoded", invalid)
+		}
+	}
+}
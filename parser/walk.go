@@ -0,0 +1,56 @@
+package parser
+
+// WalkStatus tells Walk how to go on after visiting a node.
+type WalkStatus int8
+
+const (
+	// WalkContinue goes on to the children of the node, or to the next node.
+	WalkContinue WalkStatus = iota
+	// WalkSkipChildren does not visit the children of the node, it only makes sense when entering the node.
+	WalkSkipChildren
+	// WalkStop stops walking at once.
+	WalkStop
+)
+
+// Walker is called by Walk when entering a node, and when leaving it after its children are walked.
+type Walker func(node *Node, entering bool) WalkStatus
+
+// Walk visits the node and its descendants in depth-first order, it returns WalkStop if the walker stopped it.
+// The walker may change the children of the node it is entering, and the new children are walked.
+func Walk(node *Node, walker Walker) WalkStatus {
+	status := walker(node, true)
+	if status == WalkStop {
+		return WalkStop
+	}
+	if status != WalkSkipChildren {
+		for i := 0; i < len(node.Children); i++ {
+			if Walk(node.Children[i], walker) == WalkStop {
+				return WalkStop
+			}
+		}
+	}
+	if walker(node, false) == WalkStop {
+		return WalkStop
+	}
+	return WalkContinue
+}
+
+// Transformer rewrites the AST of a document after it is parsed and before it is rendered,
+// such as rewriting the links, collecting the headings or injecting nodes.
+type Transformer interface {
+	Transform(root *Node)
+}
+
+// TransformerFunc is a function which is a Transformer.
+type TransformerFunc func(root *Node)
+
+func (f TransformerFunc) Transform(root *Node) {
+	f(root)
+}
+
+// Transform applies the transformers to the AST in order.
+func Transform(root *Node, transformers ...Transformer) {
+	for _, transformer := range transformers {
+		transformer.Transform(root)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"md2html/converter"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reloadPath is where the pages listen to the reload events sent by the server.
+const reloadPath = "/__md2html/reload"
+
+// reloadScript is injected into every page, it reloads the page when a file is changed.
+const reloadScript = `<script>
+new EventSource("` + reloadPath + `").onmessage = function () { location.reload(); };
+</script>
+`
+
+// server renders the markdown files in a directory on request, and serves the other files as they are.
+type server struct {
+	options converter.Options
+	dir     http.Dir
+	files   http.Handler
+
+	mutex sync.Mutex
+	// clients are the channels of the pages listening to the reload events.
+	clients map[chan struct{}]bool
+}
+
+func newServer(dir string, options converter.Options) *server {
+	return &server{
+		options: options,
+		dir:     http.Dir(dir),
+		files:   http.FileServer(http.Dir(dir)),
+		clients: map[chan struct{}]bool{},
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean(r.URL.Path)
+	switch {
+	case name == reloadPath:
+		s.serveReload(w, r)
+		return
+	case strings.HasSuffix(r.URL.Path, "/"):
+		// A directory is rendered from its index.md if there is one.
+		if s.exists(path.Join(name, "index.md")) {
+			s.serveMarkdown(w, r, path.Join(name, "index.md"))
+			return
+		}
+	case strings.HasSuffix(name, ".md"):
+		s.serveMarkdown(w, r, name)
+		return
+	case strings.HasSuffix(name, ".html") && !s.exists(name):
+		// The links to the converted pages work as well.
+		if markdown := strings.TrimSuffix(name, ".html") + ".md"; s.exists(markdown) {
+			s.serveMarkdown(w, r, markdown)
+			return
+		}
+	}
+	s.files.ServeHTTP(w, r)
+}
+
+func (s *server) exists(name string) bool {
+	file, err := s.dir.Open(name)
+	if err != nil {
+		return false
+	}
+	_ = file.Close()
+	return true
+}
+
+func (s *server) serveMarkdown(w http.ResponseWriter, r *http.Request, name string) {
+	file, err := s.dir.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	markdown, err := ioutil.ReadAll(file)
+	_ = file.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	options := s.options
+	options.DefaultTitle = strings.TrimSuffix(path.Base(name), ".md")
+	html := converter.ConvertWithOptions(string(markdown), options)
+	if i := strings.LastIndex(html, "</body>"); i >= 0 {
+		html = html[:i] + reloadScript + html[i:]
+	} else {
+		html += reloadScript
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(html))
+}
+
+// serveReload sends a Server-Sent Event to the page whenever a file is changed.
+func (s *server) serveReload(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	changed := make(chan struct{}, 1)
+	s.mutex.Lock()
+	s.clients[changed] = true
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.clients, changed)
+		s.mutex.Unlock()
+	}()
+	_, _ = w.Write([]byte(": connected\n\n"))
+	flusher.Flush()
+	for {
+		select {
+		case <-changed:
+			_, _ = w.Write([]byte("data: reload\n\n"))
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// notify tells every page that a file is changed.
+func (s *server) notify() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for client := range s.clients {
+		select {
+		case client <- struct{}{}:
+		default:
+			// The page has not reloaded since the last change.
+		}
+	}
+}
+
+// snapshot returns the states of all the files under the directory by their paths.
+func snapshot(dir string) map[string]fileState {
+	states := map[string]fileState{}
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			states[path] = fileState{info.ModTime(), info.Size()}
+		}
+		return nil
+	})
+	return states
+}
+
+// watch checks the files under the directory every interval, and notifies the pages when any of them is changed.
+func (s *server) watch(dir string, interval time.Duration) {
+	known := snapshot(dir)
+	for {
+		time.Sleep(interval)
+		current := snapshot(dir)
+		changed := len(current) != len(known)
+		for path, state := range current {
+			if changed {
+				break
+			}
+			changed = known[path] != state
+		}
+		if changed {
+			s.notify()
+		}
+		known = current
+	}
+}
+
+// serve runs "md2html serve [-addr address] [directory]", it serves the directory until the process is killed.
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "the address to listen on")
+	flags.Usage = func() {
+		log.SetFlags(0)
+		log.Println("Usage: md2html serve [-addr address] [directory]")
+		log.Println("Markdown files in the directory are rendered on request, and the pages reload when a file is changed.")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	dir := "."
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	} else if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		usageError("serve needs a directory.")
+	}
+	s := newServer(dir, converter.DefaultOptions())
+	go s.watch(dir, pollInterval)
+	log.Printf("Serving %q at http://%s/.", dir, *addr)
+	if err := http.ListenAndServe(*addr, s); err != nil {
+		log.Println("Error:", err)
+		os.Exit(exitFailure)
+	}
+}
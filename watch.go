@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// pollInterval is how often the markdown files are checked in watch mode.
+const pollInterval = 500 * time.Millisecond
+
+// fileState is what is checked to find out whether a markdown file has changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+type watchedFile struct {
+	source
+	state fileState
+}
+
+// scan returns the markdown files under the paths by their paths.
+// Errors are ignored, because files may be removed while they are being found.
+func scan(paths []string) map[string]watchedFile {
+	files, _ := findMarkdownFiles(paths)
+	watched := make(map[string]watchedFile, len(files))
+	for _, file := range files {
+		if fi, err := os.Stat(file.path); err == nil {
+			watched[file.path] = watchedFile{file, fileState{fi.ModTime(), fi.Size()}}
+		}
+	}
+	return watched
+}
+
+// watch converts the markdown files under the paths when they are added or changed,
+// and removes the HTML files of the removed ones. It checks the files every interval and never returns.
+// A file is converted after it stays the same for a whole interval, so that rapid saves are only converted once.
+func (c *config) watch(paths []string, interval time.Duration) {
+	log.Printf("Watching %q for changes.", paths)
+	known := scan(paths)
+	pending := map[string]bool{}
+	for {
+		time.Sleep(interval)
+		current := scan(paths)
+		for path, file := range current {
+			if old, ok := known[path]; !ok || old.state != file.state {
+				pending[path] = true
+			} else if pending[path] {
+				delete(pending, path)
+				_ = c.convert(file.source)
+			}
+		}
+		for path, file := range known {
+			if _, ok := current[path]; !ok {
+				delete(pending, path)
+				c.removeOutput(file.source)
+			}
+		}
+		known = current
+	}
+}
+
+// removeOutput removes the HTML file of a removed markdown file.
+func (c *config) removeOutput(file source) {
+	output := c.outputPath(file)
+	if err := os.Remove(output); err == nil {
+		c.logf("Removed file %q, its markdown file %q is removed.", output, file.path)
+	} else if !os.IsNotExist(err) {
+		log.Printf("Error: failed to remove file %q: %v", output, err)
+	}
+}